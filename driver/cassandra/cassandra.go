@@ -2,13 +2,16 @@
 package cassandra
 
 import (
+	"context"
 	"fmt"
 	"net/url"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/gocql/gocql"
 
+	drv "github.com/netw00rk/sqltractor/driver"
 	"github.com/netw00rk/sqltractor/tractor/migration/direction"
 	"github.com/netw00rk/sqltractor/tractor/migration/file"
 )
@@ -16,12 +19,22 @@ import (
 type Driver struct {
 	session *gocql.Session
 	url     string
+	lock    *drv.LockInfo
+
+	lockMode drv.LockMode
+	lockDone chan struct{}
+
+	// name of the version table, TABLE_NAME if empty
+	table string
 }
 
 const (
-	TABLE_NAME  = "schema_migrations"
-	LOCK_TABLE  = "schema_migrations_lock"
-	VERSION_ROW = 1
+	TABLE_NAME    = "schema_migrations"
+	HISTORY_TABLE = "schema_migrations_history"
+	DIRTY_TABLE   = "schema_migrations_dirty"
+	LOCK_TABLE    = "schema_migrations_lock"
+	LOCK_TTL      = 60 * time.Second
+	VERSION_ROW   = 1
 )
 
 // Cassandra Driver URL format:
@@ -41,6 +54,40 @@ func FromSession(session *gocql.Session) *Driver {
 	}
 }
 
+// SetVersionTable names the version table. The tables next to it
+// are named after it, like <name>_dirty and <name>_lock.
+func (driver *Driver) SetVersionTable(name string) {
+	driver.table = name
+}
+
+func (driver *Driver) versionTableName() string {
+	if driver.table == "" {
+		return TABLE_NAME
+	}
+	return driver.table
+}
+
+func (driver *Driver) historyTableName() string {
+	if driver.table == "" {
+		return HISTORY_TABLE
+	}
+	return driver.table + "_history"
+}
+
+func (driver *Driver) dirtyTableName() string {
+	if driver.table == "" {
+		return DIRTY_TABLE
+	}
+	return driver.table + "_dirty"
+}
+
+func (driver *Driver) lockTableName() string {
+	if driver.table == "" {
+		return LOCK_TABLE
+	}
+	return driver.table + "_lock"
+}
+
 func (driver *Driver) Initialize() error {
 	if driver.session != nil {
 		return nil
@@ -84,73 +131,359 @@ func (driver *Driver) Close() error {
 	return nil
 }
 
-func (driver *Driver) Lock() error {
-	if err := driver.session.Query(fmt.Sprintf("CREATE TABLE %s (lock BOOLEAN PRIMARY KEY)", LOCK_TABLE)).Exec(); err != nil {
-		return err
+func (driver *Driver) Migrate(f *file.File) error {
+	return driver.MigrateContext(context.Background(), f)
+}
+
+// MigrateContext checks ctx between statements, as Cassandra has no
+// transactions to roll back a partially applied file. The version is
+// marked as dirty until the whole file has been applied.
+func (driver *Driver) MigrateContext(ctx context.Context, f *file.File) error {
+	_, err := driver.MigrateWithStatements(ctx, f)
+	return err
+}
+
+// MigrateWithStatements reports every executed statement,
+// rows affected are unknown in Cassandra.
+func (driver *Driver) MigrateWithStatements(ctx context.Context, f *file.File) ([]*drv.Statement, error) {
+	statements := make([]*drv.Statement, 0)
+	content, err := f.Content()
+	if err != nil {
+		return statements, err
 	}
 
-	return nil
+	checksum, err := f.Checksum()
+	if err != nil {
+		return statements, err
+	}
+
+	if err := driver.session.Query(fmt.Sprintf("INSERT INTO %s (dirtyRow, version) VALUES (?, ?)", driver.dirtyTableName()), VERSION_ROW, int64(f.Version)).WithContext(ctx).Exec(); err != nil {
+		return statements, err
+	}
+
+	startedAt := time.Now()
+	if f.Func != nil {
+		if err := f.Func(ctx, driver.session); err != nil {
+			return statements, err
+		}
+	}
+
+	for _, query := range strings.Split(string(content), ";") {
+		query = strings.TrimSpace(query)
+		if len(query) == 0 {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return statements, err
+		}
+
+		queryStartedAt := time.Now()
+		if err := driver.session.Query(query).WithContext(ctx).Exec(); err != nil {
+			return statements, err
+		}
+
+		statements = append(statements, &drv.Statement{
+			Query:        query,
+			RowsAffected: -1,
+			Duration:     time.Since(queryStartedAt),
+		})
+	}
+
+	if err := driver.history(ctx, f, startedAt, checksum); err != nil {
+		return statements, err
+	}
+
+	if err := driver.updateVersion(ctx); err != nil {
+		return statements, err
+	}
+
+	err = driver.session.Query(fmt.Sprintf("DELETE FROM %s WHERE dirtyRow = ?", driver.dirtyTableName()), VERSION_ROW).WithContext(ctx).Exec()
+	return statements, err
+}
+
+func (driver *Driver) Version() (uint64, error) {
+	return driver.VersionContext(context.Background())
+}
+
+func (driver *Driver) VersionContext(ctx context.Context) (uint64, error) {
+	var version int64
+	err := driver.session.Query(fmt.Sprintf("SELECT version FROM %s WHERE versionRow = ?", driver.versionTableName()), VERSION_ROW).WithContext(ctx).Scan(&version)
+	return uint64(version) - 1, err
+}
+
+// AppliedVersions reads the history table, as the version counter only
+// knows the latest applied version. Migrations applied by releases
+// without history are recorded by Initialize, see ensureHistoryExists.
+func (driver *Driver) AppliedVersions(ctx context.Context) ([]uint64, error) {
+	iter := driver.session.Query(fmt.Sprintf("SELECT version FROM %s", driver.historyTableName())).WithContext(ctx).Iter()
+
+	versions := make([]uint64, 0)
+	var version int64
+	for iter.Scan(&version) {
+		versions = append(versions, uint64(version))
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] < versions[j]
+	})
+	return versions, nil
+}
+
+func (driver *Driver) History(ctx context.Context) ([]*drv.Record, error) {
+	query := fmt.Sprintf("SELECT version, name, applied_at, duration_ns, checksum, applied_by, tool_version FROM %s", driver.historyTableName())
+	iter := driver.session.Query(query).WithContext(ctx).Iter()
+
+	records := make([]*drv.Record, 0)
+	var version, duration int64
+	var name, checksum, appliedBy, toolVersion string
+	var appliedAt time.Time
+	for iter.Scan(&version, &name, &appliedAt, &duration, &checksum, &appliedBy, &toolVersion) {
+		records = append(records, &drv.Record{
+			Version:     uint64(version),
+			Name:        name,
+			AppliedAt:   appliedAt,
+			Duration:    time.Duration(duration),
+			Checksum:    checksum,
+			AppliedBy:   appliedBy,
+			ToolVersion: toolVersion,
+		})
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	// rows of a table scan come in token order
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Version < records[j].Version
+	})
+	return records, nil
+}
+
+func (driver *Driver) Dirty(ctx context.Context) (uint64, bool, error) {
+	var version int64
+	err := driver.session.Query(fmt.Sprintf("SELECT version FROM %s WHERE dirtyRow = ?", driver.dirtyTableName()), VERSION_ROW).WithContext(ctx).Scan(&version)
+	switch {
+	case err == gocql.ErrNotFound:
+		return 0, false, nil
+	case err != nil:
+		return 0, false, err
+	default:
+		return uint64(version), true, nil
+	}
 }
 
-func (driver *Driver) Release() error {
-	if err := driver.session.Query(fmt.Sprintf("DROP TABLE %s", LOCK_TABLE)).Exec(); err != nil {
+// Force moves the version counter to version and drops
+// history records of all versions above it.
+func (driver *Driver) Force(ctx context.Context, version uint64) error {
+	if err := driver.setVersion(ctx, version); err != nil {
 		return err
 	}
 
-	return nil
+	applied, err := driver.AppliedVersions(ctx)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, v := range applied {
+		if v == version {
+			found = true
+		}
+
+		if v > version {
+			if err := driver.session.Query(fmt.Sprintf("DELETE FROM %s WHERE version = ?", driver.historyTableName()), int64(v)).WithContext(ctx).Exec(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if version > 0 && !found {
+		query := fmt.Sprintf("INSERT INTO %s (version, applied_at, applied_by, tool_version) VALUES (?, ?, ?, ?)", driver.historyTableName())
+		if err := driver.session.Query(query, int64(version), time.Now(), drv.AppliedBy(), drv.ToolVersion).WithContext(ctx).Exec(); err != nil {
+			return err
+		}
+	}
+
+	return driver.session.Query(fmt.Sprintf("DELETE FROM %s WHERE dirtyRow = ?", driver.dirtyTableName()), VERSION_ROW).WithContext(ctx).Exec()
 }
 
-func (driver *Driver) Migrate(f *file.File) error {
-	content, err := f.Content()
+// Baseline records files as applied without running them and raises the
+// version to the highest of them. Versions already recorded are skipped.
+func (driver *Driver) Baseline(ctx context.Context, files []*file.File) error {
+	applied, err := driver.AppliedVersions(ctx)
 	if err != nil {
 		return err
 	}
 
-	for _, query := range strings.Split(string(content), ";") {
-		query = strings.TrimSpace(query)
-		if len(query) == 0 {
+	recorded := make(map[uint64]bool)
+	for _, v := range applied {
+		recorded[v] = true
+	}
+
+	var latest uint64
+	appliedAt := time.Now()
+	for _, f := range files {
+		if f.Version > latest {
+			latest = f.Version
+		}
+		if recorded[f.Version] {
 			continue
 		}
 
-		if err := driver.session.Query(query).Exec(); err != nil {
+		checksum, err := f.Checksum()
+		if err != nil {
+			return err
+		}
+
+		query := fmt.Sprintf("INSERT INTO %s (version, name, applied_at, duration_ns, checksum, applied_by, tool_version) VALUES (?, ?, ?, 0, ?, ?, ?)", driver.historyTableName())
+		if err := driver.session.Query(query, int64(f.Version), f.Name, appliedAt, checksum, drv.AppliedBy(), drv.ToolVersion).WithContext(ctx).Exec(); err != nil {
 			return err
 		}
 	}
 
-	if err := driver.version(f.Direction); err != nil {
+	current, err := driver.VersionContext(ctx)
+	if err != nil {
 		return err
 	}
 
+	if latest > current {
+		return driver.setVersion(ctx, latest)
+	}
+
 	return nil
 }
 
-func (driver *Driver) Version() (uint64, error) {
-	var version int64
-	err := driver.session.Query(fmt.Sprintf("SELECT version FROM %s WHERE versionRow = ?", TABLE_NAME), VERSION_ROW).Scan(&version)
-	return uint64(version) - 1, err
+// Drop drops all materialized views and tables of the keyspace except for the
+// driver's own tables, and forgets all applied versions. The lock table is kept
+// as is. The keyspace is taken from the url, so drivers created by FromSession can not drop.
+func (driver *Driver) Drop(ctx context.Context) error {
+	u, err := url.Parse(driver.url)
+	if err != nil {
+		return err
+	}
+
+	keyspace := strings.TrimPrefix(u.Path, "/")
+	if keyspace == "" {
+		return fmt.Errorf("unknown keyspace, drop needs a cassandra:// url")
+	}
+
+	own := map[string]bool{driver.versionTableName(): true, driver.historyTableName(): true, driver.dirtyTableName(): true, driver.lockTableName(): true}
+	statements := make([]string, 0)
+
+	// views go first, as tables can not be dropped while they have views
+	for _, object := range [][2]string{{"MATERIALIZED VIEW", "SELECT view_name FROM system_schema.views WHERE keyspace_name = ?"}, {"TABLE", "SELECT table_name FROM system_schema.tables WHERE keyspace_name = ?"}} {
+		var name string
+		iter := driver.session.Query(object[1], keyspace).WithContext(ctx).Iter()
+		for iter.Scan(&name) {
+			if !own[name] {
+				statements = append(statements, fmt.Sprintf(`DROP %s IF EXISTS "%s"."%s"`, object[0], keyspace, name))
+			}
+		}
+		if err := iter.Close(); err != nil {
+			return err
+		}
+	}
+
+	for _, statement := range statements {
+		if err := driver.session.Query(statement).WithContext(ctx).Exec(); err != nil {
+			return err
+		}
+	}
+
+	return driver.Force(ctx, 0)
 }
 
 func (driver *Driver) ensureVersionTableExists() error {
-	err := driver.session.Query(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version COUNTER, versionRow BIGINT PRIMARY KEY)", TABLE_NAME)).Exec()
+	err := driver.session.Query(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version COUNTER, versionRow BIGINT PRIMARY KEY)", driver.versionTableName())).Exec()
+	if err != nil {
+		return err
+	}
+
+	// counter tables can't hold other columns, so the history lives in its own table
+	err = driver.session.Query(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version BIGINT PRIMARY KEY, name TEXT, applied_at TIMESTAMP, duration_ns BIGINT, checksum TEXT, applied_by TEXT, tool_version TEXT)", driver.historyTableName())).Exec()
+	if err != nil {
+		return err
+	}
+
+	err = driver.session.Query(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (dirtyRow BIGINT PRIMARY KEY, version BIGINT)", driver.dirtyTableName())).Exec()
 	if err != nil {
 		return err
 	}
 
-	_, err = driver.Version()
+	version, err := driver.Version()
 	if err != nil {
-		driver.session.Query(UP.String(), VERSION_ROW).Exec()
+		driver.session.Query(UP.query(driver.versionTableName()), VERSION_ROW).Exec()
+		return nil
+	}
+
+	return driver.ensureHistoryExists(version)
+}
+
+// ensureHistoryExists records the versions applied by earlier releases, which
+// kept no history. Their counter counted the applied migrations, so these are
+// the versions 1 up to the current version.
+func (driver *Driver) ensureHistoryExists(version uint64) error {
+	if version == 0 {
+		return nil
+	}
+
+	var recorded int64
+	err := driver.session.Query(fmt.Sprintf("SELECT version FROM %s LIMIT 1", driver.historyTableName())).Scan(&recorded)
+	if err != gocql.ErrNotFound {
+		return err
 	}
 
+	for v := uint64(1); v <= version; v++ {
+		if err := driver.session.Query(fmt.Sprintf("INSERT INTO %s (version) VALUES (?)", driver.historyTableName()), int64(v)).Exec(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (driver *Driver) version(d direction.Direction) error {
-	var stmt counterStmt
-	switch d {
+func (driver *Driver) history(ctx context.Context, f *file.File, startedAt time.Time, checksum string) error {
+	switch f.Direction {
 	case direction.Up:
-		stmt = UP
+		query := fmt.Sprintf("INSERT INTO %s (version, name, applied_at, duration_ns, checksum, applied_by, tool_version) VALUES (?, ?, ?, ?, ?, ?, ?)", driver.historyTableName())
+		return driver.session.Query(query, int64(f.Version), f.Name, startedAt, int64(time.Since(startedAt)), checksum, drv.AppliedBy(), drv.ToolVersion).WithContext(ctx).Exec()
 	case direction.Down:
-		stmt = DOWN
+		return driver.session.Query(fmt.Sprintf("DELETE FROM %s WHERE version = ?", driver.historyTableName()), int64(f.Version)).WithContext(ctx).Exec()
+	}
+	return nil
+}
+
+// updateVersion moves the version counter to the highest applied version,
+// so that versions with gaps, like timestamps, are kept as they are
+func (driver *Driver) updateVersion(ctx context.Context) error {
+	applied, err := driver.AppliedVersions(ctx)
+	if err != nil {
+		return err
 	}
-	return driver.session.Query(stmt.String(), VERSION_ROW).Exec()
+
+	var latest uint64
+	if len(applied) > 0 {
+		latest = applied[len(applied)-1]
+	}
+	return driver.setVersion(ctx, latest)
+}
+
+// setVersion moves the version counter to version. Counters can
+// only be changed by a difference, which is taken under the lock.
+func (driver *Driver) setVersion(ctx context.Context, version uint64) error {
+	current, err := driver.VersionContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if delta := int64(version) - int64(current); delta != 0 {
+		query := fmt.Sprintf("UPDATE %s SET version = version + ? WHERE versionRow = ?", driver.versionTableName())
+		return driver.session.Query(query, delta, VERSION_ROW).WithContext(ctx).Exec()
+	}
+	return nil
 }
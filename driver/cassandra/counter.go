@@ -8,11 +8,16 @@ const (
 )
 
 func (c counterStmt) String() string {
+	return c.query(TABLE_NAME)
+}
+
+// query updates the counter in table
+func (c counterStmt) query(table string) string {
 	sign := ""
 	if bool(c) {
 		sign = "+"
 	} else {
 		sign = "-"
 	}
-	return "UPDATE " + TABLE_NAME + " SET version = version " + sign + " 1 where versionRow = ?"
+	return "UPDATE " + table + " SET version = version " + sign + " 1 where versionRow = ?"
 }
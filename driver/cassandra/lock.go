@@ -0,0 +1,145 @@
+package cassandra
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gocql/gocql"
+
+	drv "github.com/netw00rk/sqltractor/driver"
+)
+
+func (driver *Driver) SetLockMode(mode drv.LockMode) {
+	driver.lockMode = mode
+}
+
+func (driver *Driver) Lock() error {
+	return driver.LockContext(context.Background())
+}
+
+// LockContext inserts the only row of the lock table with a lightweight
+// transaction, which fails with drv.ErrLocked while another process holds it.
+// In native lock mode the row expires after LOCK_TTL unless the driver keeps
+// refreshing it, so a lock left by a crashed process disappears on its own.
+func (driver *Driver) LockContext(ctx context.Context) error {
+	if err := driver.ensureLockTableExists(ctx); err != nil {
+		return err
+	}
+
+	ttl := 0
+	if driver.lockMode == drv.NativeLock {
+		ttl = int(LOCK_TTL / time.Second)
+	}
+
+	info := drv.NewLockInfo()
+	query := fmt.Sprintf("INSERT INTO %s (lockRow, token, host, pid, tool_version, acquired_at, heartbeat_at) VALUES (?, ?, ?, ?, ?, ?, ?) IF NOT EXISTS USING TTL ?", driver.lockTableName())
+	applied, err := driver.session.Query(query, VERSION_ROW, info.Token, info.Host, info.Pid, info.ToolVersion, info.AcquiredAt.UnixNano(), info.HeartbeatAt.UnixNano(), ttl).
+		WithContext(ctx).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return drv.ErrLocked
+	}
+
+	driver.lock = info
+	if driver.lockMode == drv.NativeLock {
+		driver.lockDone = make(chan struct{})
+		go driver.refresh(driver.lockDone, info)
+	}
+	return nil
+}
+
+func (driver *Driver) Release() error {
+	if driver.lock == nil {
+		return nil
+	}
+
+	if driver.lockDone != nil {
+		close(driver.lockDone)
+		driver.lockDone = nil
+	}
+
+	if err := driver.Unlock(context.Background(), driver.lock.Token); err != nil {
+		return err
+	}
+
+	driver.lock = nil
+	return nil
+}
+
+func (driver *Driver) LockInfo(ctx context.Context) (*drv.LockInfo, error) {
+	if err := driver.ensureLockTableExists(ctx); err != nil {
+		return nil, err
+	}
+
+	var info drv.LockInfo
+	var acquiredAt, heartbeatAt int64
+	query := fmt.Sprintf("SELECT token, host, pid, tool_version, acquired_at, heartbeat_at FROM %s WHERE lockRow = ?", driver.lockTableName())
+	err := driver.session.Query(query, VERSION_ROW).WithContext(ctx).
+		Scan(&info.Token, &info.Host, &info.Pid, &info.ToolVersion, &acquiredAt, &heartbeatAt)
+	if err == gocql.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info.AcquiredAt = time.Unix(0, acquiredAt)
+	info.HeartbeatAt = time.Unix(0, heartbeatAt)
+	return &info, nil
+}
+
+func (driver *Driver) Heartbeat(ctx context.Context) error {
+	if driver.lock == nil {
+		return nil
+	}
+
+	return driver.heartbeat(ctx, driver.lock)
+}
+
+func (driver *Driver) heartbeat(ctx context.Context, info *drv.LockInfo) error {
+	ttl := 0
+	if driver.lockMode == drv.NativeLock {
+		ttl = int(LOCK_TTL / time.Second)
+	}
+
+	// every column is rewritten, so that none of them outlives the others
+	query := fmt.Sprintf("UPDATE %s USING TTL ? SET token = ?, host = ?, pid = ?, tool_version = ?, acquired_at = ?, heartbeat_at = ? WHERE lockRow = ? IF token = ?", driver.lockTableName())
+	applied, err := driver.session.Query(query, ttl, info.Token, info.Host, info.Pid, info.ToolVersion, info.AcquiredAt.UnixNano(), time.Now().UnixNano(), VERSION_ROW, info.Token).
+		WithContext(ctx).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return drv.ErrLockLost
+	}
+	return nil
+}
+
+func (driver *Driver) Unlock(ctx context.Context, token string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE lockRow = ? IF token = ?", driver.lockTableName())
+	_, err := driver.session.Query(query, VERSION_ROW, token).WithContext(ctx).MapScanCAS(map[string]interface{}{})
+	return err
+}
+
+// refresh renews the TTL of the lock row every third of LOCK_TTL until done is closed
+func (driver *Driver) refresh(done chan struct{}, info *drv.LockInfo) {
+	ticker := time.NewTicker(LOCK_TTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			driver.heartbeat(context.Background(), info)
+		}
+	}
+}
+
+func (driver *Driver) ensureLockTableExists(ctx context.Context) error {
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (lockRow BIGINT PRIMARY KEY, token TEXT, host TEXT, pid INT, tool_version TEXT, acquired_at BIGINT, heartbeat_at BIGINT)", driver.lockTableName())
+	return driver.session.Query(query).WithContext(ctx).Exec()
+}
@@ -1,7 +1,12 @@
 // Package driver holds the driver interface.
 package driver
 
-import "github.com/netw00rk/sqltractor/tractor/migration/file"
+import (
+	"context"
+	"time"
+
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
+)
 
 // Driver is the interface type that needs to implemented by all drivers.
 type Driver interface {
@@ -20,12 +25,87 @@ type Driver interface {
 	// to its backend or whatever.
 	Migrate(file *file.File) error
 
+	// MigrateContext is Migrate bound to ctx. Drivers should abort
+	// the statement in flight when ctx is cancelled, if the backend allows it.
+	MigrateContext(ctx context.Context, file *file.File) error
+
 	// Version returns the current migration version.
 	Version() (uint64, error)
 
+	// VersionContext is Version bound to ctx.
+	VersionContext(ctx context.Context) (uint64, error)
+
 	// Lock create lock table
 	Lock() error
 
+	// LockContext is Lock bound to ctx.
+	LockContext(ctx context.Context) error
+
 	// Release drops a lock table
 	Release() error
 }
+
+// VersionLister is implemented by drivers that can list every
+// applied version, not only the most recent one.
+type VersionLister interface {
+	// AppliedVersions returns all applied versions in ascending order.
+	AppliedVersions(ctx context.Context) ([]uint64, error)
+}
+
+// DirtyTracker is implemented by drivers that mark a version as dirty
+// while it is migrated, so that a migration failing halfway is noticed.
+// The mark is cleared once the file is applied. A failed file leaves the
+// version dirty unless the driver knows that the database is unchanged, like
+// after a transaction rolled the whole file back. Failing to clear the mark
+// fails the migration.
+type DirtyTracker interface {
+	// Dirty returns the version left dirty by a failed migration.
+	// dirty is false if the database is clean.
+	Dirty(ctx context.Context) (version uint64, dirty bool, err error)
+
+	// Force makes version the current clean version. Applied versions
+	// above it are forgotten and the dirty flag is cleared.
+	Force(ctx context.Context, version uint64) error
+}
+
+// VersionTableSelector is implemented by drivers whose
+// version table can be named, e.g. to share a database.
+type VersionTableSelector interface {
+	// SetVersionTable names the version table, before Initialize is called.
+	SetVersionTable(name string)
+}
+
+// Baseliner is implemented by drivers that can record migrations
+// as applied without running them.
+type Baseliner interface {
+	// Baseline records files as applied, skipping versions already recorded.
+	Baseline(ctx context.Context, files []*file.File) error
+}
+
+// Dropper is implemented by drivers that can wipe the database
+// schema, e.g. to reset development and test databases.
+type Dropper interface {
+	// Drop removes every object the migrations may have created and
+	// forgets all applied versions. The driver's own tables are kept.
+	Drop(ctx context.Context) error
+}
+
+// Statement describes a single statement a driver executed for a migration.
+type Statement struct {
+	// the executed query
+	Query string
+
+	// rows affected by the query, -1 if unknown
+	RowsAffected int64
+
+	// execution time of the query
+	Duration time.Duration
+}
+
+// StatementReporter is implemented by drivers that execute
+// migrations statement by statement and can report on each.
+type StatementReporter interface {
+	// MigrateWithStatements is MigrateContext, additionally returning
+	// the statements executed until it finished or failed.
+	MigrateWithStatements(ctx context.Context, file *file.File) ([]*Statement, error)
+}
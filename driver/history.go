@@ -0,0 +1,54 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"time"
+)
+
+// ToolVersion is recorded with every applied migration.
+// sqltractor-cli sets it to its own version.
+var ToolVersion = "sqltractor"
+
+// Record holds the details a driver stores about an applied migration.
+type Record struct {
+	// version of the applied migration
+	Version uint64
+
+	// migration name parsed from the filename
+	Name string
+
+	// time the migration started
+	AppliedAt time.Time
+
+	// execution time of the migration content
+	Duration time.Duration
+
+	// hex encoded SHA-256 of the applied content
+	Checksum string
+
+	// user@host that applied the migration
+	AppliedBy string
+
+	// ToolVersion at the time the migration was applied
+	ToolVersion string
+}
+
+// Historian is implemented by drivers that record the details of
+// every applied migration next to its version.
+type Historian interface {
+	// History returns records of all applied migrations ordered by version.
+	History(ctx context.Context) ([]*Record, error)
+}
+
+// AppliedBy returns user@host of the running process.
+func AppliedBy() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+
+	host, _ := os.Hostname()
+	return name + "@" + host
+}
@@ -0,0 +1,149 @@
+package driver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrLocked is returned by LockContext while another process holds the lock.
+var ErrLocked = errors.New("migration lock is held by another process")
+
+// ErrLockLost is returned by Heartbeat once another process has taken over the lock.
+var ErrLockLost = errors.New("migration lock has been taken over by another process")
+
+// ErrNativeLock is returned when removing a native lock, which only its holder can release.
+var ErrNativeLock = errors.New("native lock is released by the database when its holder disconnects")
+
+// LockMode selects how a driver implements Lock.
+type LockMode int
+
+const (
+	// NativeLock uses the locking primitive of the database,
+	// which drops the lock when its holder disconnects. This is the default.
+	NativeLock LockMode = iota
+
+	// TableLock inserts the holder into a lock table, where it stays
+	// after a crash until it goes stale or is removed with Unlock.
+	TableLock
+)
+
+// ParseLockMode parses "native" or "table".
+func ParseLockMode(mode string) (LockMode, error) {
+	switch mode {
+	case "native":
+		return NativeLock, nil
+	case "table":
+		return TableLock, nil
+	}
+
+	return NativeLock, fmt.Errorf("unknown lock mode %s", mode)
+}
+
+func (mode LockMode) String() string {
+	if mode == TableLock {
+		return "table"
+	}
+	return "native"
+}
+
+// LockModeSelector is implemented by drivers offering more than one lock mode.
+type LockModeSelector interface {
+	// SetLockMode must be called before the first Lock.
+	SetLockMode(mode LockMode)
+}
+
+// LockInfo describes the holder of the migration lock.
+type LockInfo struct {
+	// random token identifying one acquisition of the lock
+	Token string
+
+	// host and process id of the holder
+	Host string
+	Pid  int
+
+	// database session holding a native lock, like the mysql connection
+	// id or the pid of the postgres backend, 0 if unknown
+	ConnectionID int64
+
+	// ToolVersion of the holder
+	ToolVersion string
+
+	// time the lock was acquired
+	AcquiredAt time.Time
+
+	// time the holder last confirmed it is alive
+	HeartbeatAt time.Time
+
+	// Native is set for locks tied to a database session, which the database
+	// drops on disconnect. Such a lock never goes stale, and the fields
+	// above are only set as far as the database reports them.
+	Native bool
+}
+
+// NewLockInfo describes the running process as holder of a new lock.
+func NewLockInfo() *LockInfo {
+	token := make([]byte, 16)
+	rand.Read(token)
+
+	host, _ := os.Hostname()
+	now := time.Now()
+	return &LockInfo{
+		Token:       hex.EncodeToString(token),
+		Host:        host,
+		Pid:         os.Getpid(),
+		ToolVersion: ToolVersion,
+		AcquiredAt:  now,
+		HeartbeatAt: now,
+	}
+}
+
+// Stale reports whether the holder has not sent a heartbeat for longer than ttl.
+// A lock never goes stale if ttl is 0.
+func (info *LockInfo) Stale(ttl time.Duration) bool {
+	return !info.Native && ttl > 0 && time.Since(info.HeartbeatAt) > ttl
+}
+
+func (info *LockInfo) String() string {
+	host := info.Host
+	if host == "" {
+		host = "unknown host"
+	}
+
+	s := host
+	if info.Pid != 0 {
+		s += fmt.Sprintf(" pid %d", info.Pid)
+	}
+	if info.ConnectionID != 0 {
+		s += fmt.Sprintf(" connection %d", info.ConnectionID)
+	}
+	if info.ToolVersion != "" {
+		s += fmt.Sprintf(" (%s)", info.ToolVersion)
+	}
+	if !info.AcquiredAt.IsZero() {
+		s += " since " + info.AcquiredAt.Format(time.RFC3339)
+	}
+	if info.Native {
+		return s + ", native lock"
+	}
+	return s + ", last heartbeat " + info.HeartbeatAt.Format(time.RFC3339)
+}
+
+// LockInspector is implemented by drivers that record the holder
+// of the lock, so that a lock left by a crashed process can be recovered.
+type LockInspector interface {
+	// LockInfo returns the holder of the lock, nil if the lock is free.
+	LockInfo(ctx context.Context) (*LockInfo, error)
+
+	// Heartbeat confirms that the lock held by this driver is still in use.
+	// It fails with ErrLockLost if another process has taken the lock over.
+	Heartbeat(ctx context.Context) error
+
+	// Unlock removes the lock acquired with token, whoever holds it.
+	// Native locks can not be removed, Unlock returns ErrNativeLock.
+	Unlock(ctx context.Context, token string) error
+}
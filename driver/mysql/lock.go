@@ -0,0 +1,201 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/crc32"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	drv "github.com/netw00rk/sqltractor/driver"
+)
+
+func (driver *Driver) SetLockMode(mode drv.LockMode) {
+	driver.lockMode = mode
+}
+
+func (driver *Driver) Lock() error {
+	return driver.LockContext(context.Background())
+}
+
+// LockContext takes a named lock with GET_LOCK on a connection of its own,
+// which mysql releases if the process dies. In table lock mode it inserts
+// the only row of the lock table instead. Both fail with drv.ErrLocked while
+// another process holds the lock.
+func (driver *Driver) LockContext(ctx context.Context) error {
+	if driver.lockMode == drv.TableLock {
+		return driver.lockTable(ctx)
+	}
+
+	name, err := driver.lockName(ctx)
+	if err != nil {
+		return err
+	}
+
+	conn, err := driver.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0)", name).Scan(&locked); err != nil {
+		conn.Close()
+		return err
+	}
+
+	if locked.Int64 != 1 {
+		conn.Close()
+		return drv.ErrLocked
+	}
+
+	driver.lockConn = conn
+	return nil
+}
+
+func (driver *Driver) Release() error {
+	if driver.lockConn != nil {
+		name, err := driver.lockName(context.Background())
+		if err == nil {
+			_, err = driver.lockConn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", name)
+		}
+		driver.lockConn.Close()
+		driver.lockConn = nil
+		return err
+	}
+
+	if driver.lock == nil {
+		return nil
+	}
+
+	if err := driver.Unlock(context.Background(), driver.lock.Token); err != nil {
+		return err
+	}
+
+	driver.lock = nil
+	return nil
+}
+
+// LockInfo reports the connection holding the named lock, or the
+// row of the lock table in table lock mode.
+func (driver *Driver) LockInfo(ctx context.Context) (*drv.LockInfo, error) {
+	if driver.lockMode == drv.TableLock {
+		return driver.tableLockInfo(ctx)
+	}
+
+	name, err := driver.lockName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var id sql.NullInt64
+	if err := driver.db.QueryRowContext(ctx, "SELECT IS_USED_LOCK(?)", name).Scan(&id); err != nil {
+		return nil, err
+	}
+	if !id.Valid {
+		return nil, nil
+	}
+
+	info := drv.LockInfo{ConnectionID: id.Int64, Native: true}
+	err = driver.db.QueryRowContext(ctx, "SELECT HOST FROM information_schema.PROCESSLIST WHERE ID = ?", id.Int64).Scan(&info.Host)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// Heartbeat is only needed in table lock mode, named locks live as long as their connection.
+func (driver *Driver) Heartbeat(ctx context.Context) error {
+	if driver.lockMode != drv.TableLock || driver.lock == nil {
+		return nil
+	}
+
+	res, err := driver.db.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET heartbeat_at = ? WHERE token = ?", driver.lockTableName()), time.Now().UnixNano(), driver.lock.Token)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return drv.ErrLockLost
+	}
+	return nil
+}
+
+func (driver *Driver) Unlock(ctx context.Context, token string) error {
+	if driver.lockMode != drv.TableLock {
+		return drv.ErrNativeLock
+	}
+
+	_, err := driver.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE token = ?", driver.lockTableName()), token)
+	return err
+}
+
+// lockName is the name of the lock of the current database. Names longer
+// than the 64 characters mysql allows end in a checksum of the whole name,
+// so that databases and tables sharing a prefix do not block each other.
+func (driver *Driver) lockName(ctx context.Context) (string, error) {
+	var database sql.NullString
+	if err := driver.db.QueryRowContext(ctx, "SELECT DATABASE()").Scan(&database); err != nil {
+		return "", err
+	}
+
+	name := "sqltractor:" + database.String
+	if driver.table != "" {
+		name += ":" + driver.table
+	}
+	if len(name) > 64 {
+		name = fmt.Sprintf("%s:%08x", name[:55], crc32.ChecksumIEEE([]byte(name)))
+	}
+	return name, nil
+}
+
+func (driver *Driver) lockTable(ctx context.Context) error {
+	if err := driver.ensureLockTableExists(ctx); err != nil {
+		return err
+	}
+
+	info := drv.NewLockInfo()
+	query := fmt.Sprintf("INSERT INTO %s (id, token, host, pid, tool_version, acquired_at, heartbeat_at) VALUES (1, ?, ?, ?, ?, ?, ?)", driver.lockTableName())
+	if _, err := driver.db.ExecContext(ctx, query, info.Token, info.Host, info.Pid, info.ToolVersion, info.AcquiredAt.UnixNano(), info.HeartbeatAt.UnixNano()); err != nil {
+		if holder, infoErr := driver.tableLockInfo(ctx); infoErr == nil && holder != nil {
+			return drv.ErrLocked
+		}
+		return err
+	}
+
+	driver.lock = info
+	return nil
+}
+
+func (driver *Driver) tableLockInfo(ctx context.Context) (*drv.LockInfo, error) {
+	if err := driver.ensureLockTableExists(ctx); err != nil {
+		return nil, err
+	}
+
+	var info drv.LockInfo
+	var acquiredAt, heartbeatAt int64
+	query := fmt.Sprintf("SELECT token, host, pid, tool_version, acquired_at, heartbeat_at FROM %s WHERE id = 1", driver.lockTableName())
+	err := driver.db.QueryRowContext(ctx, query).Scan(&info.Token, &info.Host, &info.Pid, &info.ToolVersion, &acquiredAt, &heartbeatAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info.AcquiredAt = time.Unix(0, acquiredAt)
+	info.HeartbeatAt = time.Unix(0, heartbeatAt)
+	return &info, nil
+}
+
+func (driver *Driver) ensureLockTableExists(ctx context.Context) error {
+	_, err := driver.db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INTEGER NOT NULL PRIMARY KEY, token VARCHAR(64) NOT NULL, host VARCHAR(255), pid INTEGER, tool_version VARCHAR(255), acquired_at BIGINT, heartbeat_at BIGINT)", driver.lockTableName()))
+	if _, isWarn := err.(mysql.MySQLWarnings); err != nil && !isWarn {
+		return err
+	}
+	return nil
+}
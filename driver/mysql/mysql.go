@@ -4,31 +4,54 @@ package mysql
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-sql-driver/mysql"
 
+	drv "github.com/netw00rk/sqltractor/driver"
 	"github.com/netw00rk/sqltractor/tractor/migration/direction"
 	"github.com/netw00rk/sqltractor/tractor/migration/file"
 )
 
 type Driver struct {
-	db  *sql.DB
-	url string
+	db       *sql.DB
+	url      string
+	lock     *drv.LockInfo
+	lockMode drv.LockMode
+	lockConn *sql.Conn
+
+	// name of the version table, TABLE_NAME if empty
+	table string
 }
 
 const (
-	TABLE_NAME      = "schema_migrations"
-	LOCK_TABLE_NAME = "schema_migrations_lock"
+	TABLE_NAME       = "schema_migrations"
+	DIRTY_TABLE_NAME = "schema_migrations_dirty"
+	LOCK_TABLE_NAME  = "schema_migrations_lock"
 )
 
 var errRegexp, _ = regexp.Compile(`at line ([0-9]+)$`)
 
+// dataChangeRegexp matches statements that run inside the transaction
+var dataChangeRegexp = regexp.MustCompile(`(?i)^(INSERT|UPDATE|DELETE|REPLACE)\s`)
+
+// history columns of the version table, added to existing tables on Initialize
+var historyColumns = [][2]string{
+	{"name", "VARCHAR(255)"},
+	{"applied_at", "DATETIME"},
+	{"duration_ns", "BIGINT"},
+	{"checksum", "VARCHAR(64)"},
+	{"applied_by", "VARCHAR(255)"},
+	{"tool_version", "VARCHAR(255)"},
+}
+
 func New(url string) *Driver {
 	return &Driver{
 		url: url,
@@ -41,6 +64,33 @@ func FromConnection(db *sql.DB) *Driver {
 	}
 }
 
+// SetVersionTable names the version table. The tables next to it
+// are named after it, like <name>_dirty and <name>_lock.
+func (driver *Driver) SetVersionTable(name string) {
+	driver.table = name
+}
+
+func (driver *Driver) versionTableName() string {
+	if driver.table == "" {
+		return TABLE_NAME
+	}
+	return driver.table
+}
+
+func (driver *Driver) dirtyTableName() string {
+	if driver.table == "" {
+		return DIRTY_TABLE_NAME
+	}
+	return driver.table + "_dirty"
+}
+
+func (driver *Driver) lockTableName() string {
+	if driver.table == "" {
+		return LOCK_TABLE_NAME
+	}
+	return driver.table + "_lock"
+}
+
 func (driver *Driver) Initialize() error {
 	if driver.db != nil {
 		return nil
@@ -63,6 +113,12 @@ func (driver *Driver) Initialize() error {
 	if err := driver.ensureVersionTableExists(); err != nil {
 		return err
 	}
+
+	_, err = driver.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version BIGINT NOT NULL PRIMARY KEY)", driver.dirtyTableName()))
+	if _, isWarn := err.(mysql.MySQLWarnings); err != nil && !isWarn {
+		return err
+	}
+
 	return nil
 }
 
@@ -73,49 +129,96 @@ func (driver *Driver) Close() error {
 	return nil
 }
 
-func (driver *Driver) Lock() error {
-	if _, err := driver.db.Exec(fmt.Sprintf("CREATE TABLE %s (`lock` BOOLEAN)", LOCK_TABLE_NAME)); err != nil {
-		return err
-	}
+func (driver *Driver) Migrate(f *file.File) error {
+	return driver.MigrateContext(context.Background(), f)
+}
 
-	return nil
+// MigrateContext marks the version as dirty before migrating and clears the
+// flag on success. As DDL statements commit implicitly in MySQL, a failed
+// migration may be applied partly and leaves the version dirty. Only files
+// of plain data changes are rolled back completely, after which the flag is
+// cleared, see commitsImplicitly.
+func (driver *Driver) MigrateContext(ctx context.Context, f *file.File) error {
+	_, err := driver.MigrateWithStatements(ctx, f)
+	return err
 }
 
-func (driver *Driver) Release() error {
-	if _, err := driver.db.Exec(fmt.Sprintf("DROP TABLE %s CASCADE", LOCK_TABLE_NAME)); err != nil {
-		return err
+func (driver *Driver) MigrateWithStatements(ctx context.Context, f *file.File) ([]*drv.Statement, error) {
+	content, err := f.Content()
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	if _, err := driver.db.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (version) VALUES (?)", driver.dirtyTableName()), f.Version); err != nil {
+		return nil, err
+	}
+
+	statements, err := driver.migrate(ctx, f)
+	if err != nil && f.Func == nil && !commitsImplicitly(content) {
+		// not bound to ctx, which may be the cause of the failure
+		if _, clearErr := driver.db.Exec(fmt.Sprintf("DELETE FROM %s", driver.dirtyTableName())); clearErr != nil {
+			return statements, fmt.Errorf("%v, clearing the dirty version failed: %v", err, clearErr)
+		}
+	}
+	return statements, err
 }
 
-func (driver *Driver) Migrate(f *file.File) error {
+// commitsImplicitly reports whether content has statements other than plain
+// data changes, which may commit the transaction implicitly, see
+// https://dev.mysql.com/doc/refman/8.0/en/implicit-commit.html
+func commitsImplicitly(content []byte) bool {
+	for _, statement := range bytes.Split(content, []byte(";")) {
+		statement = bytes.TrimSpace(statement)
+		if len(statement) > 0 && !dataChangeRegexp.Match(statement) {
+			return true
+		}
+	}
+	return false
+}
+
+func (driver *Driver) migrate(ctx context.Context, f *file.File) ([]*drv.Statement, error) {
 	// http://go-database-sql.org/modifying.html, Working with Transactions
 	// You should not mingle the use of transaction-related functions such as Begin() and Commit() with SQL statements such as BEGIN and COMMIT in your SQL code.
-	tx, err := driver.db.Begin()
+	statements := make([]*drv.Statement, 0)
+	checksum, err := f.Checksum()
 	if err != nil {
-		return err
+		return statements, err
 	}
 
+	tx, err := driver.db.BeginTx(ctx, nil)
+	if err != nil {
+		return statements, err
+	}
+
+	startedAt := time.Now().UTC()
 	if f.Direction == direction.Up {
-		if _, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (version) VALUES (?)", TABLE_NAME), f.Version); err != nil {
+		query := fmt.Sprintf("INSERT INTO %s (version, name, applied_at, checksum, applied_by, tool_version) VALUES (?, ?, ?, ?, ?, ?)", driver.versionTableName())
+		if _, err := tx.ExecContext(ctx, query, f.Version, f.Name, startedAt, checksum, drv.AppliedBy(), drv.ToolVersion); err != nil {
 			if err := tx.Rollback(); err != nil {
-				return err
+				return statements, err
 			}
-			return err
+			return statements, err
 		}
 	} else if f.Direction == direction.Down {
-		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE version = ?", TABLE_NAME), f.Version); err != nil {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE version = ?", driver.versionTableName()), f.Version); err != nil {
 			if err := tx.Rollback(); err != nil {
-				return err
+				return statements, err
 			}
-			return err
+			return statements, err
 		}
 	}
 
 	content, err := f.Content()
 	if err != nil {
-		return err
+		tx.Rollback()
+		return statements, err
+	}
+
+	if f.Func != nil {
+		if err := f.Func(ctx, tx); err != nil {
+			tx.Rollback()
+			return statements, err
+		}
 	}
 
 	// TODO this is not good! unfortunately there is no mysql driver that
@@ -124,7 +227,11 @@ func (driver *Driver) Migrate(f *file.File) error {
 	for _, sqlStmt := range sqlStmts {
 		sqlStmt = bytes.TrimSpace(sqlStmt)
 		if len(sqlStmt) > 0 {
-			if _, err := tx.Exec(string(sqlStmt)); err != nil {
+			stmtStartedAt := time.Now()
+			result, err := tx.ExecContext(ctx, string(sqlStmt))
+			if err != nil {
+				tx.Rollback()
+
 				if mysqlErr, ok := err.(*mysql.MySQLError); ok {
 					var lineNo int
 					lineNoRe := errRegexp.FindStringSubmatch(mysqlErr.Message)
@@ -152,31 +259,53 @@ func (driver *Driver) Migrate(f *file.File) error {
 						message = errRegexp.ReplaceAllString(message, fmt.Sprintf("at line %v", lineNo+wsLineOffset))
 
 						errorPart := file.LinesBeforeAndAfter(sqlStmt, lineNo, 5, 5, true)
-						return errors.New(fmt.Sprintf("%s\n\n%s", message, string(errorPart)))
-					} else {
-						return errors.New(mysqlErr.Error())
-					}
-
-					if err := tx.Rollback(); err != nil {
-						return err
+						return statements, errors.New(fmt.Sprintf("%s\n\n%s", message, string(errorPart)))
 					}
 
-					return nil
+					return statements, errors.New(mysqlErr.Error())
 				}
+
+				return statements, err
+			}
+
+			rowsAffected, err := result.RowsAffected()
+			if err != nil {
+				rowsAffected = -1
 			}
+			statements = append(statements, &drv.Statement{
+				Query:        string(sqlStmt),
+				RowsAffected: rowsAffected,
+				Duration:     time.Since(stmtStartedAt),
+			})
 		}
 	}
 
+	if f.Direction == direction.Up {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET duration_ns = ? WHERE version = ?", driver.versionTableName()), int64(time.Since(startedAt)), f.Version); err != nil {
+			tx.Rollback()
+			return statements, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s", driver.dirtyTableName())); err != nil {
+		tx.Rollback()
+		return statements, err
+	}
+
 	if err := tx.Commit(); err != nil {
-		return err
+		return statements, err
 	}
 
-	return nil
+	return statements, nil
 }
 
 func (driver *Driver) Version() (uint64, error) {
+	return driver.VersionContext(context.Background())
+}
+
+func (driver *Driver) VersionContext(ctx context.Context) (uint64, error) {
 	var version uint64
-	err := driver.db.QueryRow(fmt.Sprintf("SELECT version FROM %s ORDER BY version DESC", TABLE_NAME)).Scan(&version)
+	err := driver.db.QueryRowContext(ctx, fmt.Sprintf("SELECT version FROM %s ORDER BY version DESC", driver.versionTableName())).Scan(&version)
 	switch {
 	case err == sql.ErrNoRows:
 		return 0, nil
@@ -187,11 +316,247 @@ func (driver *Driver) Version() (uint64, error) {
 	}
 }
 
+func (driver *Driver) AppliedVersions(ctx context.Context) ([]uint64, error) {
+	rows, err := driver.db.QueryContext(ctx, fmt.Sprintf("SELECT version FROM %s ORDER BY version ASC", driver.versionTableName()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make([]uint64, 0)
+	for rows.Next() {
+		var version uint64
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
+
+func (driver *Driver) History(ctx context.Context) ([]*drv.Record, error) {
+	query := fmt.Sprintf("SELECT version, name, applied_at, duration_ns, checksum, applied_by, tool_version FROM %s ORDER BY version ASC", driver.versionTableName())
+	rows, err := driver.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*drv.Record, 0)
+	for rows.Next() {
+		// applied_at is scanned as a string, as time.Time is
+		// only supported with parseTime=true in the DSN
+		var name, appliedAt, checksum, appliedBy, toolVersion sql.NullString
+		var duration sql.NullInt64
+
+		record := &drv.Record{}
+		if err := rows.Scan(&record.Version, &name, &appliedAt, &duration, &checksum, &appliedBy, &toolVersion); err != nil {
+			return nil, err
+		}
+
+		record.Name = name.String
+		record.AppliedAt = parseDatetime(appliedAt.String)
+		record.Duration = time.Duration(duration.Int64)
+		record.Checksum = checksum.String
+		record.AppliedBy = appliedBy.String
+		record.ToolVersion = toolVersion.String
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+func (driver *Driver) Dirty(ctx context.Context) (uint64, bool, error) {
+	var version uint64
+	err := driver.db.QueryRowContext(ctx, fmt.Sprintf("SELECT version FROM %s LIMIT 1", driver.dirtyTableName())).Scan(&version)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, false, nil
+	case err != nil:
+		return 0, false, err
+	default:
+		return version, true, nil
+	}
+}
+
+func (driver *Driver) Force(ctx context.Context, version uint64) error {
+	tx, err := driver.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE version > ?", driver.versionTableName()), version); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var count int
+	if err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE version = ?", driver.versionTableName()), version).Scan(&count); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if version > 0 && count == 0 {
+		query := fmt.Sprintf("INSERT INTO %s (version, applied_at, applied_by, tool_version) VALUES (?, ?, ?, ?)", driver.versionTableName())
+		if _, err := tx.ExecContext(ctx, query, version, time.Now().UTC(), drv.AppliedBy(), drv.ToolVersion); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s", driver.dirtyTableName())); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Baseline records files as applied without running them, in one
+// transaction. Versions already recorded are skipped.
+func (driver *Driver) Baseline(ctx context.Context, files []*file.File) error {
+	tx, err := driver.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	appliedAt := time.Now().UTC()
+	for _, f := range files {
+		checksum, err := f.Checksum()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		var count int
+		if err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE version = ?", driver.versionTableName()), f.Version).Scan(&count); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		query := fmt.Sprintf("INSERT INTO %s (version, name, applied_at, duration_ns, checksum, applied_by, tool_version) VALUES (?, ?, ?, 0, ?, ?, ?)", driver.versionTableName())
+		if _, err := tx.ExecContext(ctx, query, f.Version, f.Name, appliedAt, checksum, drv.AppliedBy(), drv.ToolVersion); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Drop drops all tables and views of the current database except for the
+// driver's own tables, which are emptied. The lock table is kept as is.
+// Foreign key checks are disabled meanwhile, so that the order does not matter.
+func (driver *Driver) Drop(ctx context.Context) error {
+	conn, err := driver.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	query := "SELECT TABLE_NAME, TABLE_TYPE FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME NOT IN (?, ?, ?)"
+	rows, err := conn.QueryContext(ctx, query, driver.versionTableName(), driver.dirtyTableName(), driver.lockTableName())
+	if err != nil {
+		return err
+	}
+
+	statements := make([]string, 0)
+	for rows.Next() {
+		var name, tableType string
+		if err := rows.Scan(&name, &tableType); err != nil {
+			rows.Close()
+			return err
+		}
+
+		kind := "TABLE"
+		if tableType == "VIEW" {
+			kind = "VIEW"
+		}
+		statements = append(statements, fmt.Sprintf("DROP %s `%s`", kind, strings.Replace(name, "`", "``", -1)))
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	if _, err := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "SET FOREIGN_KEY_CHECKS = 1")
+
+	statements = append(statements, fmt.Sprintf("DELETE FROM %s", driver.versionTableName()), fmt.Sprintf("DELETE FROM %s", driver.dirtyTableName()))
+	for _, statement := range statements {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (driver *Driver) ensureVersionTableExists() error {
-	_, err := driver.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version INT NOT NULL PRIMARY KEY)", TABLE_NAME))
+	_, err := driver.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version BIGINT NOT NULL PRIMARY KEY)", driver.versionTableName()))
 	if _, isWarn := err.(mysql.MySQLWarnings); err != nil && !isWarn {
 		return err
 	}
 
+	if err := driver.ensureVersionColumnIsBigint(); err != nil {
+		return err
+	}
+
+	return driver.ensureHistoryColumnsExist()
+}
+
+// ensureVersionColumnIsBigint widens the INT version column of tables
+// created by earlier releases, which can not hold timestamp versions
+func (driver *Driver) ensureVersionColumnIsBigint() error {
+	var dataType string
+	err := driver.db.QueryRow("SELECT DATA_TYPE FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = 'version'", driver.versionTableName()).Scan(&dataType)
+	if err != nil {
+		return err
+	}
+
+	if dataType == "int" {
+		if _, err := driver.db.Exec(fmt.Sprintf("ALTER TABLE %s MODIFY version BIGINT NOT NULL", driver.versionTableName())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensureHistoryColumnsExist upgrades version tables created by
+// earlier releases, which only had the version column.
+func (driver *Driver) ensureHistoryColumnsExist() error {
+	for _, column := range historyColumns {
+		var count int
+		err := driver.db.QueryRow("SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?", driver.versionTableName(), column[0]).Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		if count == 0 {
+			if _, err := driver.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", driver.versionTableName(), column[0], column[1])); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
+
+// parseDatetime parses a DATETIME value returned either as raw
+// string or, with parseTime=true, as RFC3339 formatted time.Time.
+func parseDatetime(value string) time.Time {
+	for _, layout := range []string{"2006-01-02 15:04:05", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
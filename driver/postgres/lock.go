@@ -0,0 +1,173 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/crc32"
+	"time"
+
+	drv "github.com/netw00rk/sqltractor/driver"
+)
+
+func (driver *Driver) SetLockMode(mode drv.LockMode) {
+	driver.lockMode = mode
+}
+
+func (driver *Driver) Lock() error {
+	return driver.LockContext(context.Background())
+}
+
+// LockContext takes a session level advisory lock on a connection of its own,
+// which postgres releases if the process dies. In table lock mode it inserts
+// the only row of the lock table instead. Both fail with drv.ErrLocked while
+// another process holds the lock.
+func (driver *Driver) LockContext(ctx context.Context) error {
+	if driver.lockMode == drv.TableLock {
+		return driver.lockTable(ctx)
+	}
+
+	conn, err := driver.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", driver.lockKey()).Scan(&locked); err != nil {
+		conn.Close()
+		return err
+	}
+
+	if !locked {
+		conn.Close()
+		return drv.ErrLocked
+	}
+
+	driver.lockConn = conn
+	return nil
+}
+
+func (driver *Driver) Release() error {
+	if driver.lockConn != nil {
+		_, err := driver.lockConn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", driver.lockKey())
+		driver.lockConn.Close()
+		driver.lockConn = nil
+		return err
+	}
+
+	if driver.lock == nil {
+		return nil
+	}
+
+	if err := driver.Unlock(context.Background(), driver.lock.Token); err != nil {
+		return err
+	}
+
+	driver.lock = nil
+	return nil
+}
+
+// LockInfo reports the database session holding the advisory lock, or the
+// row of the lock table in table lock mode. Of an advisory lock only the pid
+// and client host of the session are known, not since when it is held.
+func (driver *Driver) LockInfo(ctx context.Context) (*drv.LockInfo, error) {
+	if driver.lockMode == drv.TableLock {
+		return driver.tableLockInfo(ctx)
+	}
+
+	info := drv.LockInfo{Native: true}
+	query := `SELECT a.pid, COALESCE(host(a.client_addr), 'local')
+		FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'advisory' AND l.classid = 0 AND l.objid::bigint = $1 AND l.granted`
+	err := driver.db.QueryRowContext(ctx, query, driver.lockKey()).Scan(&info.ConnectionID, &info.Host)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// Heartbeat is only needed in table lock mode, advisory locks live as long as their session.
+func (driver *Driver) Heartbeat(ctx context.Context) error {
+	if driver.lockMode != drv.TableLock || driver.lock == nil {
+		return nil
+	}
+
+	res, err := driver.db.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET heartbeat_at = $1 WHERE token = $2", driver.lockTableName()), time.Now().UnixNano(), driver.lock.Token)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return drv.ErrLockLost
+	}
+	return nil
+}
+
+func (driver *Driver) Unlock(ctx context.Context, token string) error {
+	if driver.lockMode != drv.TableLock {
+		return drv.ErrNativeLock
+	}
+
+	_, err := driver.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE token = $1", driver.lockTableName()), token)
+	return err
+}
+
+// lockKey identifies the advisory lock of the version table, so that
+// applications sharing a schema with tables of their own do not block each other
+func (driver *Driver) lockKey() int64 {
+	name := "sqltractor:" + extractCurrentSchema(driver.url)
+	if driver.table != "" {
+		name += ":" + driver.table
+	}
+	return int64(crc32.ChecksumIEEE([]byte(name)))
+}
+
+func (driver *Driver) lockTable(ctx context.Context) error {
+	if err := driver.ensureLockTableExists(ctx); err != nil {
+		return err
+	}
+
+	info := drv.NewLockInfo()
+	query := fmt.Sprintf("INSERT INTO %s (id, token, host, pid, tool_version, acquired_at, heartbeat_at) VALUES (1, $1, $2, $3, $4, $5, $5)", driver.lockTableName())
+	if _, err := driver.db.ExecContext(ctx, query, info.Token, info.Host, info.Pid, info.ToolVersion, info.AcquiredAt.UnixNano()); err != nil {
+		if holder, infoErr := driver.tableLockInfo(ctx); infoErr == nil && holder != nil {
+			return drv.ErrLocked
+		}
+		return err
+	}
+
+	driver.lock = info
+	return nil
+}
+
+func (driver *Driver) tableLockInfo(ctx context.Context) (*drv.LockInfo, error) {
+	if err := driver.ensureLockTableExists(ctx); err != nil {
+		return nil, err
+	}
+
+	var info drv.LockInfo
+	var acquiredAt, heartbeatAt int64
+	query := fmt.Sprintf("SELECT token, host, pid, tool_version, acquired_at, heartbeat_at FROM %s WHERE id = 1", driver.lockTableName())
+	err := driver.db.QueryRowContext(ctx, query).Scan(&info.Token, &info.Host, &info.Pid, &info.ToolVersion, &acquiredAt, &heartbeatAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info.AcquiredAt = time.Unix(0, acquiredAt)
+	info.HeartbeatAt = time.Unix(0, heartbeatAt)
+	return &info, nil
+}
+
+func (driver *Driver) ensureLockTableExists(ctx context.Context) error {
+	_, err := driver.db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INTEGER NOT NULL PRIMARY KEY, token VARCHAR(64) NOT NULL, host VARCHAR(255), pid INTEGER, tool_version VARCHAR(255), acquired_at BIGINT, heartbeat_at BIGINT)", driver.lockTableName()))
+	return err
+}
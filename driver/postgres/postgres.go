@@ -2,29 +2,49 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/lib/pq"
 
+	drv "github.com/netw00rk/sqltractor/driver"
 	"github.com/netw00rk/sqltractor/tractor/migration/direction"
 	"github.com/netw00rk/sqltractor/tractor/migration/file"
 )
 
 type Driver struct {
-	db  *sql.DB
-	url string
+	db       *sql.DB
+	url      string
+	lock     *drv.LockInfo
+	lockMode drv.LockMode
+	lockConn *sql.Conn
+
+	// name of the version table, TABLE_NAME if empty
+	table string
 }
 
 const (
-	TABLE_NAME string = "schema_migrations"
-	LOCK_TABLE string = "schema_migrations_lock"
+	TABLE_NAME  string = "schema_migrations"
+	DIRTY_TABLE string = "schema_migrations_dirty"
+	LOCK_TABLE  string = "schema_migrations_lock"
 )
 
+// history columns of the version table, added to existing tables on Initialize
+var historyColumns = [][2]string{
+	{"name", "VARCHAR(255)"},
+	{"applied_at", "TIMESTAMP WITH TIME ZONE"},
+	{"duration_ns", "BIGINT"},
+	{"checksum", "VARCHAR(64)"},
+	{"applied_by", "VARCHAR(255)"},
+	{"tool_version", "VARCHAR(255)"},
+}
+
 func New(url string) *Driver {
 	return &Driver{
 		url: url,
@@ -37,6 +57,33 @@ func FromConnection(db *sql.DB) *Driver {
 	}
 }
 
+// SetVersionTable names the version table. The tables next to it
+// are named after it, like <name>_dirty and <name>_lock.
+func (driver *Driver) SetVersionTable(name string) {
+	driver.table = name
+}
+
+func (driver *Driver) versionTableName() string {
+	if driver.table == "" {
+		return TABLE_NAME
+	}
+	return driver.table
+}
+
+func (driver *Driver) dirtyTableName() string {
+	if driver.table == "" {
+		return DIRTY_TABLE
+	}
+	return driver.table + "_dirty"
+}
+
+func (driver *Driver) lockTableName() string {
+	if driver.table == "" {
+		return LOCK_TABLE
+	}
+	return driver.table + "_lock"
+}
+
 func (driver *Driver) Initialize() error {
 	if driver.db != nil {
 		return nil
@@ -61,6 +108,10 @@ func (driver *Driver) Initialize() error {
 		return err
 	}
 
+	if _, err := driver.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version BIGINT NOT NULL PRIMARY KEY)", driver.dirtyTableName())); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -71,37 +122,61 @@ func (driver *Driver) Close() error {
 	return nil
 }
 
-func (driver *Driver) Lock() error {
-	if _, err := driver.db.Exec(fmt.Sprintf("CREATE TABLE %s (lock BOOLEAN)", LOCK_TABLE)); err != nil {
+func (driver *Driver) Migrate(f *file.File) error {
+	return driver.MigrateContext(context.Background(), f)
+}
+
+// MigrateContext marks the version as dirty before migrating, outside of the
+// transaction so that the flag outlives a rollback. The flag is cleared on
+// success, or after a failure that the transaction rolled back completely,
+// which is not the case for tag:no_transaction content.
+func (driver *Driver) MigrateContext(ctx context.Context, f *file.File) error {
+	content, err := f.Content()
+	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	if _, err := driver.db.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (version) VALUES ($1)", driver.dirtyTableName()), f.Version); err != nil {
+		return err
+	}
+
+	if err := driver.migrate(ctx, f); err != nil {
+		if strings.Contains(string(content), "tag:no_transaction") {
+			return err
+		}
 
-func (driver *Driver) Release() error {
-	if _, err := driver.db.Exec(fmt.Sprintf("DROP TABLE %s CASCADE", LOCK_TABLE)); err != nil {
+		// not bound to ctx, which may be the cause of the failure
+		if _, clearErr := driver.db.Exec(fmt.Sprintf("DELETE FROM %s", driver.dirtyTableName())); clearErr != nil {
+			return fmt.Errorf("%v, clearing the dirty version failed: %v", err, clearErr)
+		}
 		return err
 	}
 
 	return nil
 }
 
-func (driver *Driver) Migrate(f *file.File) error {
-	tx, err := driver.db.Begin()
+func (driver *Driver) migrate(ctx context.Context, f *file.File) error {
+	checksum, err := f.Checksum()
+	if err != nil {
+		return err
+	}
+
+	tx, err := driver.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
+	startedAt := time.Now()
 	if f.Direction == direction.Up {
-		if _, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (version) VALUES ($1)", TABLE_NAME), f.Version); err != nil {
+		query := fmt.Sprintf("INSERT INTO %s (version, name, applied_at, checksum, applied_by, tool_version) VALUES ($1, $2, $3, $4, $5, $6)", driver.versionTableName())
+		if _, err := tx.ExecContext(ctx, query, f.Version, f.Name, startedAt, checksum, drv.AppliedBy(), drv.ToolVersion); err != nil {
 			if err := tx.Rollback(); err != nil {
 				return err
 			}
 			return err
 		}
 	} else if f.Direction == direction.Down {
-		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE version=$1", TABLE_NAME), f.Version); err != nil {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE version=$1", driver.versionTableName()), f.Version); err != nil {
 			if err := tx.Rollback(); err != nil {
 				return err
 			}
@@ -111,33 +186,50 @@ func (driver *Driver) Migrate(f *file.File) error {
 
 	byteContent, err := f.Content()
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 	content := string(byteContent)
 
 	err = nil
-	if strings.Contains(content, "tag:no_transaction") {
-		_, err = driver.db.Exec(content)
+	if f.Func != nil {
+		err = f.Func(ctx, tx)
+	} else if strings.Contains(content, "tag:no_transaction") {
+		_, err = driver.db.ExecContext(ctx, content)
 	} else {
-		_, err = tx.Exec(content)
+		_, err = tx.ExecContext(ctx, content)
 	}
 
 	if err != nil {
-		pqErr := err.(*pq.Error)
+		tx.Rollback()
+
+		pqErr, ok := err.(*pq.Error)
+		if !ok {
+			return err
+		}
+
 		offset, err := strconv.Atoi(pqErr.Position)
 		if err == nil && offset >= 0 {
 			lineNo, columnNo := file.LineColumnFromOffset(byteContent, offset-1)
 			errorPart := file.LinesBeforeAndAfter(byteContent, lineNo, 5, 5, true)
 			return errors.New(fmt.Sprintf("%s %v: %s in line %v, column %v:\n\n%s", pqErr.Severity, pqErr.Code, pqErr.Message, lineNo, columnNo, string(errorPart)))
-		} else {
-			return errors.New(fmt.Sprintf("%s %v: %s", pqErr.Severity, pqErr.Code, pqErr.Message))
 		}
 
-		if err := tx.Rollback(); err != nil {
+		return errors.New(fmt.Sprintf("%s %v: %s", pqErr.Severity, pqErr.Code, pqErr.Message))
+	}
+
+	if f.Direction == direction.Up {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET duration_ns=$1 WHERE version=$2", driver.versionTableName()), int64(time.Since(startedAt)), f.Version); err != nil {
+			tx.Rollback()
 			return err
 		}
 	}
 
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s", driver.dirtyTableName())); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if err := tx.Commit(); err != nil {
 		return err
 	}
@@ -146,8 +238,12 @@ func (driver *Driver) Migrate(f *file.File) error {
 }
 
 func (driver *Driver) Version() (uint64, error) {
+	return driver.VersionContext(context.Background())
+}
+
+func (driver *Driver) VersionContext(ctx context.Context) (uint64, error) {
 	var version uint64
-	err := driver.db.QueryRow(fmt.Sprintf("SELECT version FROM %s ORDER BY version DESC LIMIT 1", TABLE_NAME)).Scan(&version)
+	err := driver.db.QueryRowContext(ctx, fmt.Sprintf("SELECT version FROM %s ORDER BY version DESC LIMIT 1", driver.versionTableName())).Scan(&version)
 	switch {
 	case err == sql.ErrNoRows:
 		return 0, nil
@@ -158,6 +254,215 @@ func (driver *Driver) Version() (uint64, error) {
 	}
 }
 
+func (driver *Driver) AppliedVersions(ctx context.Context) ([]uint64, error) {
+	rows, err := driver.db.QueryContext(ctx, fmt.Sprintf("SELECT version FROM %s ORDER BY version ASC", driver.versionTableName()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make([]uint64, 0)
+	for rows.Next() {
+		var version uint64
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
+
+func (driver *Driver) History(ctx context.Context) ([]*drv.Record, error) {
+	query := fmt.Sprintf("SELECT version, name, applied_at, duration_ns, checksum, applied_by, tool_version FROM %s ORDER BY version ASC", driver.versionTableName())
+	rows, err := driver.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*drv.Record, 0)
+	for rows.Next() {
+		var name, checksum, appliedBy, toolVersion sql.NullString
+		var appliedAt sql.NullTime
+		var duration sql.NullInt64
+
+		record := &drv.Record{}
+		if err := rows.Scan(&record.Version, &name, &appliedAt, &duration, &checksum, &appliedBy, &toolVersion); err != nil {
+			return nil, err
+		}
+
+		record.Name = name.String
+		record.AppliedAt = appliedAt.Time
+		record.Duration = time.Duration(duration.Int64)
+		record.Checksum = checksum.String
+		record.AppliedBy = appliedBy.String
+		record.ToolVersion = toolVersion.String
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+func (driver *Driver) Dirty(ctx context.Context) (uint64, bool, error) {
+	var version uint64
+	err := driver.db.QueryRowContext(ctx, fmt.Sprintf("SELECT version FROM %s LIMIT 1", driver.dirtyTableName())).Scan(&version)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, false, nil
+	case err != nil:
+		return 0, false, err
+	default:
+		return version, true, nil
+	}
+}
+
+func (driver *Driver) Force(ctx context.Context, version uint64) error {
+	tx, err := driver.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE version > $1", driver.versionTableName()), version); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var count int
+	if err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE version = $1", driver.versionTableName()), version).Scan(&count); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if version > 0 && count == 0 {
+		query := fmt.Sprintf("INSERT INTO %s (version, applied_at, applied_by, tool_version) VALUES ($1, $2, $3, $4)", driver.versionTableName())
+		if _, err := tx.ExecContext(ctx, query, version, time.Now(), drv.AppliedBy(), drv.ToolVersion); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s", driver.dirtyTableName())); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Baseline records files as applied without running them, in one
+// transaction. Versions already recorded are skipped.
+func (driver *Driver) Baseline(ctx context.Context, files []*file.File) error {
+	tx, err := driver.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	appliedAt := time.Now()
+	for _, f := range files {
+		checksum, err := f.Checksum()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		var count int
+		if err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE version = $1", driver.versionTableName()), f.Version).Scan(&count); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		query := fmt.Sprintf("INSERT INTO %s (version, name, applied_at, duration_ns, checksum, applied_by, tool_version) VALUES ($1, $2, $3, 0, $4, $5, $6)", driver.versionTableName())
+		if _, err := tx.ExecContext(ctx, query, f.Version, f.Name, appliedAt, checksum, drv.AppliedBy(), drv.ToolVersion); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Drop drops the tables, views, sequences, routines and types of the current
+// schema in one transaction, except for objects owned by extensions or by
+// other objects, which go with their owner, and the driver's own tables, which
+// are emptied. The lock table is kept as is. Dropping routines needs
+// PostgreSQL 11 or later.
+func (driver *Driver) Drop(ctx context.Context) error {
+	statements, err := driver.dropStatements(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := driver.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	statements = append(statements, fmt.Sprintf("DELETE FROM %s", driver.versionTableName()), fmt.Sprintf("DELETE FROM %s", driver.dirtyTableName()))
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// dropStatements lists a DROP statement for every object of the current schema
+// that Drop removes. Objects dropped by an earlier CASCADE are skipped by IF EXISTS.
+func (driver *Driver) dropStatements(ctx context.Context) ([]string, error) {
+	// objects of extensions and internal objects like identity sequences can not be dropped on their own
+	notOwned := "NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.objid = %s AND d.deptype IN ('e', 'i'))"
+	own := []interface{}{driver.versionTableName(), driver.dirtyTableName(), driver.lockTableName()}
+	queries := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`SELECT CASE c.relkind WHEN 'v' THEN 'VIEW' WHEN 'm' THEN 'MATERIALIZED VIEW' WHEN 'S' THEN 'SEQUENCE' WHEN 'f' THEN 'FOREIGN TABLE' ELSE 'TABLE' END, quote_ident(c.relname)
+			FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE n.nspname = current_schema() AND c.relkind IN ('r', 'p', 'v', 'm', 'S', 'f')
+			AND c.relname NOT IN ($1, $2, $3)
+			AND ` + fmt.Sprintf(notOwned, "c.oid"), own},
+		{`SELECT 'ROUTINE', p.oid::regprocedure::text
+			FROM pg_proc p JOIN pg_namespace n ON n.oid = p.pronamespace
+			WHERE n.nspname = current_schema() AND ` + fmt.Sprintf(notOwned, "p.oid"), nil},
+		{`SELECT CASE t.typtype WHEN 'd' THEN 'DOMAIN' ELSE 'TYPE' END, quote_ident(t.typname)
+			FROM pg_type t JOIN pg_namespace n ON n.oid = t.typnamespace
+			WHERE n.nspname = current_schema()
+			AND (t.typtype IN ('e', 'd', 'r') OR (t.typtype = 'c' AND (SELECT c.relkind FROM pg_class c WHERE c.oid = t.typrelid) = 'c'))
+			AND ` + fmt.Sprintf(notOwned, "t.oid"), nil},
+	}
+
+	statements := make([]string, 0)
+	for _, query := range queries {
+		rows, err := driver.db.QueryContext(ctx, query.query, query.args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var kind, name string
+			if err := rows.Scan(&kind, &name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			statements = append(statements, fmt.Sprintf("DROP %s IF EXISTS %s CASCADE", kind, name))
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return statements, nil
+}
+
 func (driver *Driver) ensureSchemaExists(schema, user string) error {
 	if schema != "" {
 		if _, err := driver.db.Exec(fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", schema)); err != nil {
@@ -173,14 +478,60 @@ func (driver *Driver) ensureVersionTableExists(schema string) error {
 	}
 
 	var count int
-	err := driver.db.QueryRow("SELECT COUNT(*) as count FROM pg_tables WHERE schemaname = $1 and tablename = $2", schema, TABLE_NAME).Scan(&count)
+	err := driver.db.QueryRow("SELECT COUNT(*) as count FROM pg_tables WHERE schemaname = $1 and tablename = $2", schema, driver.versionTableName()).Scan(&count)
+	if err != nil {
+		return err
+	}
+
 	if count == 0 {
-		if _, err := driver.db.Exec(fmt.Sprintf("CREATE TABLE %s (version INTEGER NOT NULL PRIMARY KEY)", TABLE_NAME)); err != nil {
+		if _, err := driver.db.Exec(fmt.Sprintf("CREATE TABLE %s (version BIGINT NOT NULL PRIMARY KEY)", driver.versionTableName())); err != nil {
+			return err
+		}
+	}
+
+	if err := driver.ensureVersionColumnIsBigint(schema); err != nil {
+		return err
+	}
+
+	return driver.ensureHistoryColumnsExist(schema)
+}
+
+// ensureVersionColumnIsBigint widens the INTEGER version column of tables
+// created by earlier releases, which can not hold timestamp versions
+func (driver *Driver) ensureVersionColumnIsBigint(schema string) error {
+	var dataType string
+	err := driver.db.QueryRow("SELECT data_type FROM information_schema.columns WHERE table_schema = $1 AND table_name = $2 AND column_name = 'version'", schema, driver.versionTableName()).Scan(&dataType)
+	if err != nil {
+		return err
+	}
+
+	if dataType == "integer" {
+		if _, err := driver.db.Exec(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN version TYPE BIGINT", driver.versionTableName())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensureHistoryColumnsExist upgrades version tables created by
+// earlier releases, which only had the version column.
+func (driver *Driver) ensureHistoryColumnsExist(schema string) error {
+	for _, column := range historyColumns {
+		var count int
+		err := driver.db.QueryRow("SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = $1 AND table_name = $2 AND column_name = $3", schema, driver.versionTableName(), column[0]).Scan(&count)
+		if err != nil {
 			return err
 		}
+
+		if count == 0 {
+			if _, err := driver.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", driver.versionTableName(), column[0], column[1])); err != nil {
+				return err
+			}
+		}
 	}
 
-	return err
+	return nil
 }
 
 func extractCurrentSchema(rawurl string) string {
@@ -0,0 +1,240 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+
+	drv "github.com/netw00rk/sqltractor/driver"
+)
+
+func (driver *Driver) SetLockMode(mode drv.LockMode) {
+	driver.lockMode = mode
+}
+
+func (driver *Driver) Lock() error {
+	return driver.LockContext(context.Background())
+}
+
+// LockContext holds an exclusive transaction on the lock file next to the
+// database, so that the operating system releases the lock if the process dies
+// while migrations go on unhindered. Databases without a file and the table lock
+// mode insert the only row of the lock table instead. Both fail with
+// drv.ErrLocked while another process holds the lock.
+func (driver *Driver) LockContext(ctx context.Context) error {
+	if driver.lockFile() == "" {
+		return driver.lockTable(ctx)
+	}
+
+	db, conn, err := driver.exclusive(ctx)
+	if err != nil {
+		return err
+	}
+
+	driver.lockDB = db
+	driver.lockConn = conn
+	return nil
+}
+
+func (driver *Driver) Release() error {
+	if driver.lockConn != nil {
+		err := driver.releaseFile(driver.lockDB, driver.lockConn)
+		driver.lockConn = nil
+		driver.lockDB = nil
+		return err
+	}
+
+	if driver.lock == nil {
+		return nil
+	}
+
+	if err := driver.Unlock(context.Background(), driver.lock.Token); err != nil {
+		return err
+	}
+
+	driver.lock = nil
+	return nil
+}
+
+// LockInfo tells whether the lock file is held, which does not reveal its
+// holder, or returns the row of the lock table in table lock mode.
+func (driver *Driver) LockInfo(ctx context.Context) (*drv.LockInfo, error) {
+	if driver.lockFile() == "" {
+		return driver.tableLockInfo(ctx)
+	}
+
+	if _, err := os.Stat(driver.lockFile()); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	db, conn, err := driver.exclusive(ctx)
+	if err == drv.ErrLocked {
+		return &drv.LockInfo{Native: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, driver.releaseFile(db, conn)
+}
+
+// Heartbeat is only needed in table lock mode, file locks live as long as their process.
+func (driver *Driver) Heartbeat(ctx context.Context) error {
+	if driver.lock == nil {
+		return nil
+	}
+
+	res, err := driver.db.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET heartbeat_at = ? WHERE token = ?", driver.lockTableName()), time.Now().UnixNano(), driver.lock.Token)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return drv.ErrLockLost
+	}
+	return nil
+}
+
+func (driver *Driver) Unlock(ctx context.Context, token string) error {
+	if driver.lockFile() != "" {
+		return drv.ErrNativeLock
+	}
+
+	_, err := driver.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE token = ?", driver.lockTableName()), token)
+	return err
+}
+
+// lockFile is the file locked in native lock mode, empty if the
+// table lock is used because the database has no file
+func (driver *Driver) lockFile() string {
+	if driver.lockMode == drv.TableLock {
+		return ""
+	}
+
+	filename := strings.SplitN(driver.url, "sqlite3://", 2)
+	if len(filename) != 2 {
+		return ""
+	}
+
+	path := strings.SplitN(filename[1], "?", 2)[0]
+	if path == "" || strings.HasPrefix(path, ":memory:") || strings.Contains(filename[1], "mode=memory") {
+		return ""
+	}
+
+	if driver.table != "" {
+		return path + "." + driver.table + ".lock"
+	}
+	return path + ".lock"
+}
+
+// exclusive begins an exclusive transaction on the lock file without waiting.
+// The file is removed while the lock is held, see releaseFile, so the lock
+// only counts if the file is still in place once the transaction began.
+func (driver *Driver) exclusive(ctx context.Context) (*sql.DB, *sql.Conn, error) {
+	f, err := os.OpenFile(driver.lockFile(), os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	f.Close()
+
+	// a file that is gone has been released in between
+	before, err := os.Stat(driver.lockFile())
+	if os.IsNotExist(err) {
+		return nil, nil, drv.ErrLocked
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db, err := sql.Open("sqlite3", driver.lockFile()+"?_busy_timeout=0")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err == nil {
+		if _, err = conn.ExecContext(ctx, "BEGIN EXCLUSIVE"); err != nil {
+			conn.Close()
+		}
+	}
+
+	if err != nil {
+		db.Close()
+		if sqliteErr, isErr := err.(sqlite3.Error); isErr && sqliteErr.Code == sqlite3.ErrBusy {
+			return nil, nil, drv.ErrLocked
+		}
+		return nil, nil, err
+	}
+
+	if after, err := os.Stat(driver.lockFile()); err != nil || !os.SameFile(before, after) {
+		conn.ExecContext(ctx, "ROLLBACK")
+		conn.Close()
+		db.Close()
+		return nil, nil, drv.ErrLocked
+	}
+
+	return db, conn, nil
+}
+
+// releaseFile removes the lock file and then ends the exclusive transaction,
+// so that no other process can lock the file once it is gone. Systems that
+// do not remove open files keep it.
+func (driver *Driver) releaseFile(db *sql.DB, conn *sql.Conn) error {
+	os.Remove(driver.lockFile())
+
+	_, err := conn.ExecContext(context.Background(), "ROLLBACK")
+	conn.Close()
+	db.Close()
+	return err
+}
+
+func (driver *Driver) lockTable(ctx context.Context) error {
+	if err := driver.ensureLockTableExists(ctx); err != nil {
+		return err
+	}
+
+	info := drv.NewLockInfo()
+	query := fmt.Sprintf("INSERT INTO %s (id, token, host, pid, tool_version, acquired_at, heartbeat_at) VALUES (1, ?, ?, ?, ?, ?, ?)", driver.lockTableName())
+	if _, err := driver.db.ExecContext(ctx, query, info.Token, info.Host, info.Pid, info.ToolVersion, info.AcquiredAt.UnixNano(), info.HeartbeatAt.UnixNano()); err != nil {
+		if holder, infoErr := driver.tableLockInfo(ctx); infoErr == nil && holder != nil {
+			return drv.ErrLocked
+		}
+		return err
+	}
+
+	driver.lock = info
+	return nil
+}
+
+func (driver *Driver) tableLockInfo(ctx context.Context) (*drv.LockInfo, error) {
+	if err := driver.ensureLockTableExists(ctx); err != nil {
+		return nil, err
+	}
+
+	var info drv.LockInfo
+	var acquiredAt, heartbeatAt int64
+	query := fmt.Sprintf("SELECT token, host, pid, tool_version, acquired_at, heartbeat_at FROM %s WHERE id = 1", driver.lockTableName())
+	err := driver.db.QueryRowContext(ctx, query).Scan(&info.Token, &info.Host, &info.Pid, &info.ToolVersion, &acquiredAt, &heartbeatAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info.AcquiredAt = time.Unix(0, acquiredAt)
+	info.HeartbeatAt = time.Unix(0, heartbeatAt)
+	return &info, nil
+}
+
+func (driver *Driver) ensureLockTableExists(ctx context.Context) error {
+	_, err := driver.db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INTEGER NOT NULL PRIMARY KEY, token TEXT NOT NULL, host TEXT, pid INTEGER, tool_version TEXT, acquired_at INTEGER, heartbeat_at INTEGER)", driver.lockTableName()))
+	return err
+}
@@ -2,27 +2,48 @@
 package sqlite3
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/mattn/go-sqlite3"
 
+	drv "github.com/netw00rk/sqltractor/driver"
 	"github.com/netw00rk/sqltractor/tractor/migration/direction"
 	"github.com/netw00rk/sqltractor/tractor/migration/file"
 )
 
 type Driver struct {
-	db  *sql.DB
-	url string
+	db       *sql.DB
+	url      string
+	lock     *drv.LockInfo
+	lockMode drv.LockMode
+	lockConn *sql.Conn
+	lockDB   *sql.DB
+
+	// name of the version table, TABLE_NAME if empty
+	table string
 }
 
 const (
-	TABLE_NAME      = "schema_migration"
-	LOCK_TABLE_NAME = "schema_migration_lock"
+	TABLE_NAME       = "schema_migration"
+	DIRTY_TABLE_NAME = "schema_migration_dirty"
+	LOCK_TABLE_NAME  = "schema_migration_lock"
 )
 
+// history columns of the version table, added to existing tables on Initialize
+var historyColumns = [][2]string{
+	{"name", "TEXT"},
+	{"applied_at", "TIMESTAMP"},
+	{"duration_ns", "INTEGER"},
+	{"checksum", "TEXT"},
+	{"applied_by", "TEXT"},
+	{"tool_version", "TEXT"},
+}
+
 func New(url string) *Driver {
 	return &Driver{
 		url: url,
@@ -35,6 +56,33 @@ func FromConnection(db *sql.DB) *Driver {
 	}
 }
 
+// SetVersionTable names the version table. The tables next to it
+// are named after it, like <name>_dirty and <name>_lock.
+func (driver *Driver) SetVersionTable(name string) {
+	driver.table = name
+}
+
+func (driver *Driver) versionTableName() string {
+	if driver.table == "" {
+		return TABLE_NAME
+	}
+	return driver.table
+}
+
+func (driver *Driver) dirtyTableName() string {
+	if driver.table == "" {
+		return DIRTY_TABLE_NAME
+	}
+	return driver.table + "_dirty"
+}
+
+func (driver *Driver) lockTableName() string {
+	if driver.table == "" {
+		return LOCK_TABLE_NAME
+	}
+	return driver.table + "_lock"
+}
+
 func (driver *Driver) Initialize() error {
 	if driver.db != nil {
 		return nil
@@ -57,6 +105,11 @@ func (driver *Driver) Initialize() error {
 	if err := driver.ensureVersionTableExists(); err != nil {
 		return err
 	}
+
+	if _, err := driver.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version INTEGER NOT NULL PRIMARY KEY)", driver.dirtyTableName())); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -67,37 +120,50 @@ func (driver *Driver) Close() error {
 	return nil
 }
 
-func (driver *Driver) Lock() error {
-	if _, err := driver.db.Exec(fmt.Sprintf("CREATE TABLE %s (lock INTEGER NOT NULL);", LOCK_TABLE_NAME)); err != nil {
+func (driver *Driver) Migrate(f *file.File) error {
+	return driver.MigrateContext(context.Background(), f)
+}
+
+// MigrateContext marks the version as dirty before migrating. The flag is
+// cleared on success and after failures, which the transaction rolled back.
+func (driver *Driver) MigrateContext(ctx context.Context, f *file.File) error {
+	if _, err := driver.db.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (version) VALUES (?)", driver.dirtyTableName()), f.Version); err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func (driver *Driver) Release() error {
-	if _, err := driver.db.Exec(fmt.Sprintf("DROP TABLE %s", LOCK_TABLE_NAME)); err != nil {
+	if err := driver.migrate(ctx, f); err != nil {
+		// not bound to ctx, which may be the cause of the failure
+		if _, clearErr := driver.db.Exec(fmt.Sprintf("DELETE FROM %s", driver.dirtyTableName())); clearErr != nil {
+			return fmt.Errorf("%v, clearing the dirty version failed: %v", err, clearErr)
+		}
 		return err
 	}
 
 	return nil
 }
 
-func (driver *Driver) Migrate(f *file.File) error {
-	tx, err := driver.db.Begin()
+func (driver *Driver) migrate(ctx context.Context, f *file.File) error {
+	checksum, err := f.Checksum()
 	if err != nil {
 		return err
 	}
 
+	tx, err := driver.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	startedAt := time.Now()
 	if f.Direction == direction.Up {
-		if _, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (version) VALUES (?)", TABLE_NAME), f.Version); err != nil {
+		query := fmt.Sprintf("INSERT INTO %s (version, name, applied_at, checksum, applied_by, tool_version) VALUES (?, ?, ?, ?, ?, ?)", driver.versionTableName())
+		if _, err := tx.ExecContext(ctx, query, f.Version, f.Name, startedAt, checksum, drv.AppliedBy(), drv.ToolVersion); err != nil {
 			if err := tx.Rollback(); err != nil {
 				return err
 			}
 			return err
 		}
 	} else if f.Direction == direction.Down {
-		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE version=?", TABLE_NAME), f.Version); err != nil {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE version=?", driver.versionTableName()), f.Version); err != nil {
 			if err := tx.Rollback(); err != nil {
 				return err
 			}
@@ -107,22 +173,39 @@ func (driver *Driver) Migrate(f *file.File) error {
 
 	content, err := f.Content()
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	if _, err := tx.Exec(string(content)); err != nil {
+	if f.Func != nil {
+		err = f.Func(ctx, tx)
+	} else {
+		_, err = tx.ExecContext(ctx, string(content))
+	}
+
+	if err != nil {
+		tx.Rollback()
+
 		if sqliteErr, isErr := err.(sqlite3.Error); isErr {
 			// The sqlite3 library only provides error codes, not position information. Output what we do know
 			return errors.New(fmt.Sprintf("SQLite Error (%s); Extended (%s)\nError: %s", sqliteErr.Code.Error(), sqliteErr.ExtendedCode.Error(), sqliteErr.Error()))
-		} else {
-			return errors.New(fmt.Sprintf("An error occurred: %s", err.Error()))
 		}
 
-		if err := tx.Rollback(); err != nil {
+		return errors.New(fmt.Sprintf("An error occurred: %s", err.Error()))
+	}
+
+	if f.Direction == direction.Up {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET duration_ns=? WHERE version=?", driver.versionTableName()), int64(time.Since(startedAt)), f.Version); err != nil {
+			tx.Rollback()
 			return err
 		}
 	}
 
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s", driver.dirtyTableName())); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if err := tx.Commit(); err != nil {
 		return err
 	}
@@ -131,8 +214,12 @@ func (driver *Driver) Migrate(f *file.File) error {
 }
 
 func (driver *Driver) Version() (uint64, error) {
+	return driver.VersionContext(context.Background())
+}
+
+func (driver *Driver) VersionContext(ctx context.Context) (uint64, error) {
 	var version uint64
-	err := driver.db.QueryRow(fmt.Sprintf("SELECT version FROM %s ORDER BY version DESC LIMIT 1", TABLE_NAME)).Scan(&version)
+	err := driver.db.QueryRowContext(ctx, fmt.Sprintf("SELECT version FROM %s ORDER BY version DESC LIMIT 1", driver.versionTableName())).Scan(&version)
 	switch {
 	case err == sql.ErrNoRows:
 		return 0, nil
@@ -143,9 +230,214 @@ func (driver *Driver) Version() (uint64, error) {
 	}
 }
 
+func (driver *Driver) AppliedVersions(ctx context.Context) ([]uint64, error) {
+	rows, err := driver.db.QueryContext(ctx, fmt.Sprintf("SELECT version FROM %s ORDER BY version ASC", driver.versionTableName()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make([]uint64, 0)
+	for rows.Next() {
+		var version uint64
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
+
+func (driver *Driver) History(ctx context.Context) ([]*drv.Record, error) {
+	query := fmt.Sprintf("SELECT version, name, applied_at, duration_ns, checksum, applied_by, tool_version FROM %s ORDER BY version ASC", driver.versionTableName())
+	rows, err := driver.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*drv.Record, 0)
+	for rows.Next() {
+		var name, checksum, appliedBy, toolVersion sql.NullString
+		var appliedAt sql.NullTime
+		var duration sql.NullInt64
+
+		record := &drv.Record{}
+		if err := rows.Scan(&record.Version, &name, &appliedAt, &duration, &checksum, &appliedBy, &toolVersion); err != nil {
+			return nil, err
+		}
+
+		record.Name = name.String
+		record.AppliedAt = appliedAt.Time
+		record.Duration = time.Duration(duration.Int64)
+		record.Checksum = checksum.String
+		record.AppliedBy = appliedBy.String
+		record.ToolVersion = toolVersion.String
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+func (driver *Driver) Dirty(ctx context.Context) (uint64, bool, error) {
+	var version uint64
+	err := driver.db.QueryRowContext(ctx, fmt.Sprintf("SELECT version FROM %s LIMIT 1", driver.dirtyTableName())).Scan(&version)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, false, nil
+	case err != nil:
+		return 0, false, err
+	default:
+		return version, true, nil
+	}
+}
+
+func (driver *Driver) Force(ctx context.Context, version uint64) error {
+	tx, err := driver.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE version > ?", driver.versionTableName()), version); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var count int
+	if err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE version = ?", driver.versionTableName()), version).Scan(&count); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if version > 0 && count == 0 {
+		query := fmt.Sprintf("INSERT INTO %s (version, applied_at, applied_by, tool_version) VALUES (?, ?, ?, ?)", driver.versionTableName())
+		if _, err := tx.ExecContext(ctx, query, version, time.Now(), drv.AppliedBy(), drv.ToolVersion); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s", driver.dirtyTableName())); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Baseline records files as applied without running them, in one
+// transaction. Versions already recorded are skipped.
+func (driver *Driver) Baseline(ctx context.Context, files []*file.File) error {
+	tx, err := driver.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	appliedAt := time.Now()
+	for _, f := range files {
+		checksum, err := f.Checksum()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		var count int
+		if err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE version = ?", driver.versionTableName()), f.Version).Scan(&count); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		query := fmt.Sprintf("INSERT INTO %s (version, name, applied_at, duration_ns, checksum, applied_by, tool_version) VALUES (?, ?, ?, 0, ?, ?, ?)", driver.versionTableName())
+		if _, err := tx.ExecContext(ctx, query, f.Version, f.Name, appliedAt, checksum, drv.AppliedBy(), drv.ToolVersion); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Drop drops all triggers, views and tables in one transaction, except for the
+// driver's own tables, which are emptied. The lock table is kept as is.
+func (driver *Driver) Drop(ctx context.Context) error {
+	query := `SELECT type, name FROM sqlite_master
+		WHERE type IN ('trigger', 'view', 'table') AND name NOT LIKE 'sqlite_%' AND name NOT IN (?, ?, ?)
+		ORDER BY CASE type WHEN 'trigger' THEN 0 WHEN 'view' THEN 1 ELSE 2 END`
+	rows, err := driver.db.QueryContext(ctx, query, driver.versionTableName(), driver.dirtyTableName(), driver.lockTableName())
+	if err != nil {
+		return err
+	}
+
+	statements := make([]string, 0)
+	for rows.Next() {
+		var kind, name string
+		if err := rows.Scan(&kind, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		statements = append(statements, fmt.Sprintf(`DROP %s IF EXISTS "%s"`, strings.ToUpper(kind), strings.Replace(name, `"`, `""`, -1)))
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	tx, err := driver.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	statements = append(statements, fmt.Sprintf("DELETE FROM %s", driver.versionTableName()), fmt.Sprintf("DELETE FROM %s", driver.dirtyTableName()))
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 func (driver *Driver) ensureVersionTableExists() error {
-	if _, err := driver.db.Exec("CREATE TABLE IF NOT EXISTS " + TABLE_NAME + " (version INTEGER PRIMARY KEY AUTOINCREMENT);"); err != nil {
+	if _, err := driver.db.Exec("CREATE TABLE IF NOT EXISTS " + driver.versionTableName() + " (version INTEGER PRIMARY KEY AUTOINCREMENT);"); err != nil {
 		return err
 	}
+	return driver.ensureHistoryColumnsExist()
+}
+
+// ensureHistoryColumnsExist upgrades version tables created by
+// earlier releases, which only had the version column.
+func (driver *Driver) ensureHistoryColumnsExist() error {
+	rows, err := driver.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", driver.versionTableName()))
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+
+	for _, column := range historyColumns {
+		if !existing[column[0]] {
+			if _, err := driver.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", driver.versionTableName(), column[0], column[1])); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
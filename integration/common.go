@@ -1,6 +1,7 @@
 package integration
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os/exec"
@@ -10,7 +11,12 @@ import (
 
 	"github.com/netw00rk/sqltractor/driver"
 	"github.com/netw00rk/sqltractor/reader"
+	"github.com/netw00rk/sqltractor/reader/function"
+	"github.com/netw00rk/sqltractor/reader/memory"
 	"github.com/netw00rk/sqltractor/tractor"
+	"github.com/netw00rk/sqltractor/tractor/migration"
+	"github.com/netw00rk/sqltractor/tractor/migration/direction"
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
 )
 
 type DriverTestSuite struct {
@@ -59,6 +65,33 @@ func (s *DriverTestSuite) TestUpAsyncDownAsync() {
 	s.Equal(uint64(0), version)
 }
 
+func (s *DriverTestSuite) TestResults() {
+	t := &tractor.SqlTractor{
+		Driver: s.Driver,
+		Reader: s.Reader,
+	}
+
+	results := make([]tractor.Result, 0)
+	for r := range t.UpAsync() {
+		s.Nil(r.Error)
+		results = append(results, r)
+	}
+
+	s.Equal(5, len(results))
+	s.Equal(tractor.PhaseLocking, results[0].Phase)
+	for i, r := range results[1:4] {
+		s.Equal(tractor.PhaseMigrating, r.Phase)
+		s.NotNil(r.File)
+		s.Equal(uint64(i+1), r.Version)
+		s.Equal(direction.Up, r.Direction)
+		s.False(r.FinishedAt.Before(r.StartedAt))
+	}
+	s.Equal(tractor.PhaseReleasing, results[4].Phase)
+
+	_, err := tractor.Down(t)
+	s.Nil(err)
+}
+
 func (s *DriverTestSuite) TestUpDown() {
 	t := &tractor.SqlTractor{
 		Driver: s.Driver,
@@ -125,6 +158,690 @@ func (s *DriverTestSuite) TestMigrateUpDown() {
 	s.Equal(uint64(0), version)
 }
 
+func (s *DriverTestSuite) TestGoto() {
+	t := &tractor.SqlTractor{
+		Driver: s.Driver,
+		Reader: s.Reader,
+	}
+
+	files, err := tractor.Goto(t, 2)
+	s.Equal(2, len(files))
+	s.Nil(err)
+
+	version, _ := t.Version()
+	s.Equal(uint64(2), version)
+
+	_, err = tractor.Goto(t, 42)
+	s.NotNil(err)
+
+	files, err = tractor.Goto(t, 0)
+	s.Equal(2, len(files))
+	s.Nil(err)
+
+	version, _ = t.Version()
+	s.Equal(uint64(0), version)
+}
+
+func (s *DriverTestSuite) TestRedo() {
+	t := &tractor.SqlTractor{
+		Driver: s.Driver,
+		Reader: s.Reader,
+	}
+
+	_, err := tractor.Migrate(t, +1)
+	s.Nil(err)
+
+	files, err := tractor.Redo(t, 1)
+	s.Nil(err)
+	s.Equal(2, len(files))
+	s.Equal(uint64(1), files[0].Version)
+	s.Equal(direction.Direction(direction.Down), files[0].Direction)
+	s.Equal(uint64(1), files[1].Version)
+	s.Equal(direction.Direction(direction.Up), files[1].Direction)
+
+	version, _ := t.Version()
+	s.Equal(uint64(1), version)
+
+	_, err = tractor.Down(t)
+	s.Nil(err)
+
+	files, err = tractor.Redo(t, 1)
+	s.Nil(err)
+	s.Equal(0, len(files))
+}
+
+func (s *DriverTestSuite) TestPlan() {
+	t := &tractor.SqlTractor{
+		Driver: s.Driver,
+		Reader: s.Reader,
+	}
+
+	plan, err := t.PlanUp(context.Background())
+	s.Nil(err)
+	s.Equal(uint64(0), plan.Version)
+	s.Equal(3, len(plan.Steps))
+
+	version, _ := t.Version()
+	s.Equal(uint64(0), version)
+}
+
+func (s *DriverTestSuite) TestHistory() {
+	t := &tractor.SqlTractor{
+		Driver: s.Driver,
+		Reader: s.Reader,
+	}
+
+	_, err := tractor.Up(t)
+	s.Nil(err)
+
+	records, err := t.History(context.Background())
+	s.Nil(err)
+	s.Equal(3, len(records))
+	for i, record := range records {
+		s.Equal(uint64(i+1), record.Version)
+		s.Equal("test", record.Name)
+		s.Equal(64, len(record.Checksum))
+		s.False(record.AppliedAt.IsZero())
+		s.NotEmpty(record.AppliedBy)
+	}
+
+	_, err = tractor.Down(t)
+	s.Nil(err)
+
+	records, err = t.History(context.Background())
+	s.Nil(err)
+	s.Equal(0, len(records))
+}
+
+func (s *DriverTestSuite) TestValidate() {
+	t := &tractor.SqlTractor{
+		Driver: s.Driver,
+		Reader: s.Reader,
+	}
+
+	_, err := tractor.Migrate(t, +2)
+	s.Nil(err)
+
+	drift, err := t.Validate(context.Background())
+	s.Nil(err)
+	s.True(drift.Empty())
+
+	files, err := s.Reader.Read()
+	s.Nil(err)
+
+	modified := make(map[string][]byte)
+	for _, f := range files {
+		content, _ := f.Content()
+		if f.FileName == "001_test.up.sql" {
+			content = append([]byte("-- modified\n"), content...)
+		}
+		modified[f.FileName] = content
+	}
+
+	t = &tractor.SqlTractor{
+		Driver: s.Driver,
+		Reader: memory.NewMemoryReader(modified),
+	}
+
+	drift, err = t.Validate(context.Background())
+	s.Nil(err)
+	s.Equal(1, len(drift.Modified))
+
+	_, err = tractor.Up(t)
+	s.NotNil(err)
+
+	t.IgnoreDrift = true
+	_, err = tractor.Up(t)
+	s.Nil(err)
+
+	_, err = tractor.Down(t)
+	s.Nil(err)
+}
+
+func (s *DriverTestSuite) TestStatus() {
+	t := &tractor.SqlTractor{
+		Driver: s.Driver,
+		Reader: s.Reader,
+	}
+
+	_, err := tractor.Migrate(t, +2)
+	s.Nil(err)
+
+	statuses, err := t.Status(context.Background())
+	s.Nil(err)
+	s.Equal(3, len(statuses))
+	for i, status := range statuses {
+		s.Equal(uint64(i+1), status.Version)
+		s.Equal("test", status.Name)
+		s.True(status.HasUp)
+		s.True(status.HasDown)
+		s.False(status.Missing)
+	}
+	s.True(statuses[0].Applied)
+	s.True(statuses[1].Applied)
+	s.False(statuses[2].Applied)
+
+	files, err := s.Reader.Read()
+	s.Nil(err)
+
+	withoutSecond := make(map[string][]byte)
+	for _, f := range files {
+		if f.Version != 2 {
+			withoutSecond[f.FileName], _ = f.Content()
+		}
+	}
+
+	statuses, err = (&tractor.SqlTractor{
+		Driver: s.Driver,
+		Reader: memory.NewMemoryReader(withoutSecond),
+	}).Status(context.Background())
+	s.Nil(err)
+	s.Equal(3, len(statuses))
+	s.True(statuses[1].Missing)
+	s.True(statuses[1].Applied)
+	s.False(statuses[1].HasUp)
+
+	_, err = tractor.Down(t)
+	s.Nil(err)
+}
+
+func (s *DriverTestSuite) TestOutOfOrder() {
+	files, err := s.Reader.Read()
+	s.Nil(err)
+
+	withoutSecond := make(map[string][]byte)
+	for _, f := range files {
+		if f.Version != 2 {
+			withoutSecond[f.FileName], _ = f.Content()
+		}
+	}
+
+	t := &tractor.SqlTractor{
+		Driver: s.Driver,
+		Reader: memory.NewMemoryReader(withoutSecond),
+	}
+
+	_, err = tractor.Up(t)
+	s.Nil(err)
+
+	t = &tractor.SqlTractor{
+		Driver: s.Driver,
+		Reader: s.Reader,
+	}
+
+	_, err = tractor.Up(t)
+	s.NotNil(err, "version 2 should be reported as unknown")
+
+	t.OutOfOrder = true
+	plan, err := t.PlanUp(context.Background())
+	s.Nil(err)
+	s.Equal(1, len(plan.Steps))
+	s.True(plan.Steps[0].OutOfOrder)
+
+	files, err = tractor.Up(t)
+	s.Nil(err)
+	s.Equal(1, len(files))
+
+	versions, err := t.AppliedVersions(context.Background())
+	s.Nil(err)
+	s.Equal([]uint64{1, 2, 3}, versions)
+
+	_, err = tractor.Down(t)
+	s.Nil(err)
+}
+
+func (s *DriverTestSuite) TestDirtyForce() {
+	files, err := s.Reader.Read()
+	s.Nil(err)
+
+	broken := make(map[string][]byte)
+	for _, f := range files {
+		broken[f.FileName], _ = f.Content()
+	}
+	broken["004_broken.up.sql"] = []byte("NOT A STATEMENT")
+	broken["004_broken.down.sql"] = []byte("")
+
+	t := &tractor.SqlTractor{
+		Driver: s.Driver,
+		Reader: memory.NewMemoryReader(broken),
+	}
+
+	_, err = tractor.Up(t)
+	s.NotNil(err)
+
+	version, _ := t.Version()
+	s.Equal(uint64(3), version)
+
+	if _, dirty, err := t.Dirty(context.Background()); err == nil && dirty {
+		_, err = tractor.Down(t)
+		_, isDirty := err.(*tractor.DirtyError)
+		s.True(isDirty)
+	}
+
+	s.Nil(t.Force(context.Background(), 3))
+
+	_, dirty, err := t.Dirty(context.Background())
+	s.Nil(err)
+	s.False(dirty)
+
+	_, err = tractor.Down(t)
+	s.Nil(err)
+}
+
+type recordingObserver struct {
+	tractor.NopObserver
+	events []string
+	veto   uint64
+}
+
+func (o *recordingObserver) BeforeRun(ctx context.Context, files []*file.File) error {
+	o.events = append(o.events, fmt.Sprintf("run %d", len(files)))
+	return nil
+}
+
+func (o *recordingObserver) BeforeMigration(ctx context.Context, f *file.File) error {
+	if f.Version == o.veto {
+		return errors.New("vetoed")
+	}
+	o.events = append(o.events, "before "+f.FileName)
+	return nil
+}
+
+func (o *recordingObserver) AfterMigration(ctx context.Context, f *file.File, duration time.Duration, err error) error {
+	o.events = append(o.events, "after "+f.FileName)
+	return nil
+}
+
+func (o *recordingObserver) AfterRun(ctx context.Context, err error) {
+	o.events = append(o.events, fmt.Sprintf("done %v", err))
+}
+
+func (s *DriverTestSuite) TestBaseline() {
+	t := &tractor.SqlTractor{
+		Driver: s.Driver,
+		Reader: s.Reader,
+	}
+
+	_, err := t.Baseline(context.Background(), 99, false)
+	s.NotNil(err)
+
+	files, err := t.Baseline(context.Background(), 2, false)
+	s.Nil(err)
+	s.Equal(2, len(files))
+
+	version, _ := t.Version()
+	s.Equal(uint64(2), version)
+
+	drift, err := t.Validate(context.Background())
+	s.Nil(err)
+	s.True(drift.Empty())
+
+	_, err = t.Baseline(context.Background(), 1, false)
+	s.NotNil(err)
+
+	files, err = tractor.Up(t)
+	s.Nil(err)
+	s.Equal(1, len(files))
+	s.Equal(uint64(3), files[0].Version)
+
+	// the baselined schema was never created, so forget it instead of migrating down
+	s.Nil(t.Force(context.Background(), 0))
+}
+
+func (s *DriverTestSuite) TestSquash() {
+	t := &tractor.SqlTractor{
+		Driver: s.Driver,
+		Reader: s.Reader,
+	}
+
+	_, err := tractor.Up(t)
+	s.Nil(err)
+
+	up, down, _, err := t.Squash(2)
+	s.Nil(err)
+
+	files, err := s.Reader.Read()
+	s.Nil(err)
+
+	squashed := make(map[string][]byte)
+	for _, f := range append(files, up, down) {
+		if f.Version >= 2 {
+			squashed[f.FileName], _ = f.Content()
+		}
+	}
+
+	squashedTractor := &tractor.SqlTractor{
+		Driver: s.Driver,
+		Reader: memory.NewMemoryReader(squashed),
+	}
+
+	drift, err := squashedTractor.Validate(context.Background())
+	s.Nil(err)
+	s.True(drift.Empty())
+
+	applied, err := tractor.Up(squashedTractor)
+	s.Nil(err)
+	s.Equal(0, len(applied))
+
+	_, err = tractor.Down(t)
+	s.Nil(err)
+
+	// a database between the replaced versions must not run them again
+	_, err = tractor.Migrate(t, +1)
+	s.Nil(err)
+
+	applied, err = tractor.Up(squashedTractor)
+	s.NotNil(err)
+	s.Contains(err.Error(), "squashed")
+	s.Equal(0, len(applied))
+
+	version, _ := t.Version()
+	s.Equal(uint64(1), version)
+
+	_, err = tractor.Down(t)
+	s.Nil(err)
+
+	// a fresh database applies the squashed migration in one go
+	applied, err = tractor.Up(squashedTractor)
+	s.Nil(err)
+	s.Equal(2, len(applied))
+
+	version, _ = squashedTractor.Version()
+	s.Equal(uint64(3), version)
+
+	_, err = tractor.Down(squashedTractor)
+	s.Nil(err)
+
+	// a database that applied the replaced versions one by one must not revert
+	// them with the squashed down file, which removes only its own version
+	_, err = tractor.Up(t)
+	s.Nil(err)
+
+	applied, err = tractor.Down(squashedTractor)
+	s.NotNil(err)
+	s.Contains(err.Error(), "squashed")
+	s.Equal(0, len(applied))
+
+	version, _ = t.Version()
+	s.Equal(uint64(3), version)
+
+	_, err = tractor.Down(t)
+	s.Nil(err)
+}
+
+func (s *DriverTestSuite) TestDrop() {
+	t := &tractor.SqlTractor{
+		Driver: s.Driver,
+		Reader: s.Reader,
+	}
+
+	_, err := tractor.Up(t)
+	s.Nil(err)
+
+	s.Nil(t.Drop(context.Background()))
+
+	version, err := t.Version()
+	s.Nil(err)
+	s.Equal(uint64(0), version)
+
+	// the tables of the first migration are gone, so it applies again
+	files, err := tractor.Up(t)
+	s.Nil(err)
+	s.Equal(3, len(files))
+
+	_, err = tractor.Down(t)
+	s.Nil(err)
+}
+
+func (s *DriverTestSuite) TestLock() {
+	observer := &recordingObserver{}
+	t := &tractor.SqlTractor{
+		Driver:      s.Driver,
+		Reader:      s.Reader,
+		LockTimeout: time.Second,
+		Observers:   []tractor.Observer{observer},
+	}
+
+	s.Nil(s.Driver.Lock())
+
+	holder, err := t.LockStatus(context.Background())
+	s.Nil(err)
+	s.NotNil(holder)
+
+	_, err = tractor.Up(t)
+	_, isLocked := err.(*tractor.LockedError)
+	s.True(isLocked)
+	s.Equal([]string{fmt.Sprintf("done %v", err)}, observer.events)
+
+	s.Nil(s.Driver.Release())
+
+	holder, err = t.LockStatus(context.Background())
+	s.Nil(err)
+	s.Nil(holder)
+
+	files, err := tractor.Up(t)
+	s.Nil(err)
+	s.Equal(3, len(files))
+
+	_, err = tractor.Down(t)
+	s.Nil(err)
+}
+
+func (s *DriverTestSuite) TestLockWait() {
+	t := &tractor.SqlTractor{
+		Driver:      s.Driver,
+		Reader:      s.Reader,
+		LockTimeout: 10 * time.Second,
+	}
+
+	s.Nil(s.Driver.Lock())
+	results := t.UpAsync()
+
+	// another process migrates while up waits for the lock
+	manager, err := migration.NewManager(s.Reader)
+	s.Nil(err)
+	for _, f := range manager.ToLastFrom(0) {
+		s.Nil(s.Driver.MigrateContext(context.Background(), f))
+	}
+	s.Nil(s.Driver.Release())
+
+	for r := range results {
+		s.Nil(r.Error)
+		s.NotEqual(tractor.PhaseMigrating, r.Phase)
+	}
+
+	version, err := t.Version()
+	s.Nil(err)
+	s.Equal(uint64(3), version)
+
+	_, err = tractor.Down(t)
+	s.Nil(err)
+}
+
+func (s *DriverTestSuite) TestTableLock() {
+	selector, ok := s.Driver.(driver.LockModeSelector)
+	if !ok {
+		s.T().Skip("driver has a single lock mode")
+	}
+	selector.SetLockMode(driver.TableLock)
+	defer selector.SetLockMode(driver.NativeLock)
+
+	t := &tractor.SqlTractor{
+		Driver:      s.Driver,
+		Reader:      s.Reader,
+		LockTimeout: time.Second,
+	}
+
+	s.Nil(s.Driver.Lock())
+
+	holder, err := t.LockStatus(context.Background())
+	s.Nil(err)
+	s.NotNil(holder)
+
+	_, err = tractor.Up(t)
+	locked, isLocked := err.(*tractor.LockedError)
+	s.True(isLocked)
+	s.Equal(holder.Token, locked.Holder.Token)
+
+	_, err = t.Unlock(context.Background(), false)
+	s.NotNil(err)
+
+	t.LockTTL = time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+
+	files, err := tractor.Up(t)
+	s.Nil(err)
+	s.Equal(3, len(files))
+
+	holder, err = t.LockStatus(context.Background())
+	s.Nil(err)
+	s.Nil(holder)
+
+	_, err = tractor.Down(t)
+	s.Nil(err)
+}
+
+func (s *DriverTestSuite) TestObservers() {
+	observer := &recordingObserver{veto: 2}
+	t := &tractor.SqlTractor{
+		Driver:    s.Driver,
+		Reader:    s.Reader,
+		Observers: []tractor.Observer{observer},
+	}
+
+	_, err := tractor.Up(t)
+	s.NotNil(err)
+	s.Equal([]string{"run 3", "before 001_test.up.sql", "after 001_test.up.sql", "done vetoed"}, observer.events)
+
+	version, _ := t.Version()
+	s.Equal(uint64(1), version)
+
+	observer.veto = 0
+	_, err = tractor.Down(t)
+	s.Nil(err)
+}
+
+// lockThief takes the lock over from the run it observes
+// and waits for the run to be stopped
+type lockThief struct {
+	tractor.NopObserver
+	t *tractor.SqlTractor
+}
+
+func (o *lockThief) BeforeRun(ctx context.Context, files []*file.File) error {
+	holder, err := o.t.Unlock(context.Background(), true)
+	if err != nil {
+		return err
+	}
+	if holder == nil {
+		return errors.New("lock is not held")
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(5 * time.Second):
+		return errors.New("run went on without the lock")
+	}
+}
+
+func (s *DriverTestSuite) TestLockLost() {
+	selector, ok := s.Driver.(driver.LockModeSelector)
+	if !ok {
+		s.T().Skip("driver has a single lock mode")
+	}
+	selector.SetLockMode(driver.TableLock)
+	defer selector.SetLockMode(driver.NativeLock)
+
+	t := &tractor.SqlTractor{
+		Driver:      s.Driver,
+		Reader:      s.Reader,
+		LockTimeout: time.Second,
+		LockTTL:     300 * time.Millisecond,
+	}
+	t.Observers = []tractor.Observer{&lockThief{t: t}}
+
+	var lost error
+	for r := range t.UpAsync() {
+		if r.Phase == tractor.PhaseLocking && r.Error != nil {
+			lost = r.Error
+		}
+	}
+	s.NotNil(lost)
+	s.Contains(lost.Error(), driver.ErrLockLost.Error())
+
+	version, _ := t.Version()
+	s.Equal(uint64(0), version)
+}
+
+func (s *DriverTestSuite) TestFuncMigrations() {
+	calls := make([]string, 0)
+	record := func(name string) file.MigrationFunc {
+		return func(ctx context.Context, conn interface{}) error {
+			if conn == nil {
+				return errors.New("missing connection")
+			}
+			calls = append(calls, name)
+			return nil
+		}
+	}
+
+	t := &tractor.SqlTractor{
+		Driver: s.Driver,
+		Reader: function.NewFunctionReader(s.Reader).Add(4, "go", record("up"), record("down")),
+	}
+
+	files, err := tractor.Up(t)
+	s.Nil(err)
+	s.Equal(4, len(files))
+	s.Equal("004_go.up.go", files[3].FileName)
+
+	version, _ := t.Version()
+	s.Equal(uint64(4), version)
+
+	drift, err := t.Validate(context.Background())
+	s.Nil(err)
+	s.True(drift.Empty())
+
+	renamed := &tractor.SqlTractor{
+		Driver: s.Driver,
+		Reader: function.NewFunctionReader(s.Reader).Add(4, "renamed", record("up"), record("down")),
+	}
+	drift, err = renamed.Validate(context.Background())
+	s.Nil(err)
+	s.Equal(1, len(drift.Modified))
+
+	files, err = tractor.Down(t)
+	s.Nil(err)
+	s.Equal(4, len(files))
+	s.Equal([]string{"up", "down"}, calls)
+}
+
+func (s *DriverTestSuite) TestUpContextCancelled() {
+	t := &tractor.SqlTractor{
+		Driver: s.Driver,
+		Reader: s.Reader,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	files, err := tractor.UpContext(ctx, t)
+	s.Equal(0, len(files))
+	s.NotNil(err)
+
+	version, _ := t.Version()
+	s.Equal(uint64(0), version)
+
+	files, err = tractor.Up(t)
+	s.Equal(3, len(files))
+	s.Nil(err)
+
+	files, err = tractor.Down(t)
+	s.Equal(3, len(files))
+	s.Nil(err)
+}
+
 func RepeatWhileError(fn func() error) error {
 	startTime := time.Now()
 	ticker := time.NewTicker(1 * time.Second)
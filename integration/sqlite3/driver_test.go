@@ -42,8 +42,21 @@ func (s *SqliteTestSuite) SetupSuite() {
 	s.DriverTestSuite.Reader = memory.NewMemoryReader(files)
 }
 
+func (s *SqliteTestSuite) TestLockFileRemoved() {
+	s.Nil(s.Driver.Lock())
+
+	_, err := os.Stat("integration_test.sqlite3.lock")
+	s.Nil(err)
+
+	s.Nil(s.Driver.Release())
+
+	_, err = os.Stat("integration_test.sqlite3.lock")
+	s.True(os.IsNotExist(err))
+}
+
 func (s *SqliteTestSuite) TearDownSuite() {
 	os.Remove("integration_test.sqlite3")
+	os.Remove("integration_test.sqlite3.lock")
 }
 
 func TestSqliteTestSuite(t *testing.T) {
@@ -4,15 +4,27 @@ import (
 	"io/ioutil"
 	"path"
 
+	"github.com/netw00rk/sqltractor/reader"
 	"github.com/netw00rk/sqltractor/tractor/migration/file"
 )
 
 type FileReader struct {
 	Path string
+
+	// Strict makes Read fail with a reader.InvalidFilesError
+	// instead of skipping files it can not parse
+	Strict bool
+
+	// Ignore holds path.Match patterns of files that are
+	// no migrations, they are skipped in strict mode as well
+	Ignore []string
 }
 
 func NewFileReader(path string) *FileReader {
-	return &FileReader{path}
+	return &FileReader{
+		Path:   path,
+		Ignore: reader.DefaultIgnore,
+	}
 }
 
 func (r *FileReader) Read() ([]*file.File, error) {
@@ -21,17 +33,17 @@ func (r *FileReader) Read() ([]*file.File, error) {
 		return nil, err
 	}
 
-	files := make([]*file.File, 0)
+	names := make([]string, 0, len(ioFiles))
 	for _, ioFile := range ioFiles {
-		if file, err := file.NewFile(ioFile.Name(), r.buildContentFunc(ioFile.Name())); err == nil {
-			files = append(files, file)
+		if !ioFile.IsDir() {
+			names = append(names, ioFile.Name())
 		}
 	}
 
-	return files, nil
+	return reader.Filter(names, r.Ignore, r.Strict, r.buildContentFunc)
 }
 
-func (r *FileReader) buildContentFunc(name string) func() ([]byte, error) {
+func (r *FileReader) buildContentFunc(name string) file.ContentFunc {
 	return func() ([]byte, error) {
 		return ioutil.ReadFile(path.Join(r.Path, name))
 	}
@@ -8,6 +8,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/suite"
+
+	readerpkg "github.com/netw00rk/sqltractor/reader"
 )
 
 type FileReaderTestSuite struct {
@@ -36,6 +38,32 @@ func (s *FileReaderTestSuite) TestReadFiles() {
 	s.Equal([]byte("test"), content)
 }
 
+func (s *FileReaderTestSuite) TestStrict() {
+	dir, _ := ioutil.TempDir("/tmp", "TestStrictFileReader")
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(path.Join(dir, "001_init.up.sql"), nil, 0755)
+	ioutil.WriteFile(path.Join(dir, "001_init.upp.sql"), nil, 0755)
+	ioutil.WriteFile(path.Join(dir, "01a_x.up.sql"), nil, 0755)
+	ioutil.WriteFile(path.Join(dir, "README.md"), nil, 0755)
+	ioutil.WriteFile(path.Join(dir, ".gitkeep"), nil, 0755)
+	ioutil.WriteFile(path.Join(dir, ".001_init.up.sql.swp"), nil, 0755)
+	os.Mkdir(path.Join(dir, "seeds"), 0755)
+
+	reader := NewFileReader(dir)
+	files, err := reader.Read()
+	s.Nil(err)
+	s.Equal(1, len(files))
+
+	reader.Strict = true
+	_, err = reader.Read()
+	invalid, ok := err.(readerpkg.InvalidFilesError)
+	s.True(ok)
+	s.Equal(2, len(invalid))
+	s.Equal("001_init.upp.sql", invalid[0].FileName)
+	s.Equal("01a_x.up.sql", invalid[1].FileName)
+}
+
 func Test(t *testing.T) {
 	suite.Run(t, new(FileReaderTestSuite))
 }
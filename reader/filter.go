@@ -0,0 +1,54 @@
+package reader
+
+import (
+	"path"
+
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
+)
+
+// DefaultIgnore matches files that commonly live next to migration files
+var DefaultIgnore = []string{
+	"README*",
+	".gitkeep",
+	".gitignore",
+	".DS_Store",
+	".*.sw?", // vim swap files
+	"*~",
+	"#*#",
+}
+
+// Filter turns the names of the files in a directory into migration files,
+// reading their content with contentFunc. Names matching a path.Match pattern
+// of ignore are skipped. Names that are no valid migration file names are
+// skipped too, unless strict is set, which fails with an InvalidFilesError.
+func Filter(names, ignore []string, strict bool, contentFunc func(name string) file.ContentFunc) ([]*file.File, error) {
+	files := make([]*file.File, 0)
+	invalid := make(InvalidFilesError, 0)
+	for _, name := range names {
+		if ignored(ignore, name) {
+			continue
+		}
+
+		file, err := file.NewFile(name, contentFunc(name))
+		if err != nil {
+			invalid = append(invalid, &InvalidFile{FileName: name, Err: err})
+			continue
+		}
+		files = append(files, file)
+	}
+
+	if strict && len(invalid) > 0 {
+		return nil, invalid
+	}
+
+	return files, nil
+}
+
+func ignored(ignore []string, name string) bool {
+	for _, pattern := range ignore {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package reader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
+)
+
+type FilterTestSuite struct {
+	suite.Suite
+}
+
+func (s *FilterTestSuite) TestFilter() {
+	contentFunc := func(name string) file.ContentFunc {
+		return func() ([]byte, error) { return []byte(name), nil }
+	}
+
+	files, err := Filter([]string{"001_init.up.sql", "notes.txt", "002_x.up.sql~"}, DefaultIgnore, false, contentFunc)
+	s.Nil(err)
+	s.Equal(1, len(files))
+	content, _ := files[0].Content()
+	s.Equal([]byte("001_init.up.sql"), content)
+
+	_, err = Filter([]string{"001_init.up.sql", "notes.txt"}, DefaultIgnore, true, contentFunc)
+	s.NotNil(err)
+
+	files, err = Filter([]string{"001_init.up.sql", "notes.txt"}, append([]string{"*.txt"}, DefaultIgnore...), true, contentFunc)
+	s.Nil(err)
+	s.Equal(1, len(files))
+}
+
+func TestFilter(t *testing.T) {
+	suite.Run(t, new(FilterTestSuite))
+}
@@ -0,0 +1,67 @@
+// Package fs reads migration files from an io/fs file system, like the
+// embed.FS of a //go:embed directive, os.DirFS or fstest.MapFS.
+package fs
+
+import (
+	iofs "io/fs"
+	"path"
+
+	"github.com/netw00rk/sqltractor/reader"
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
+)
+
+type FSReader struct {
+	FS iofs.FS
+
+	// directory of the migration files in FS, "." for its root
+	Path string
+
+	// Strict makes Read fail with a reader.InvalidFilesError
+	// instead of skipping files it can not parse
+	Strict bool
+
+	// Ignore holds path.Match patterns of files that are
+	// no migrations, they are skipped in strict mode as well
+	Ignore []string
+}
+
+// NewFSReader returns a reader of the migration files in dir of fsys.
+// Their content is read when a migration needs it, e.g.
+//
+//	//go:embed migrations/*.sql
+//	var migrations embed.FS
+//
+//	r := fs.NewFSReader(migrations, "migrations")
+func NewFSReader(fsys iofs.FS, dir string) *FSReader {
+	if dir == "" {
+		dir = "."
+	}
+
+	return &FSReader{
+		FS:     fsys,
+		Path:   dir,
+		Ignore: reader.DefaultIgnore,
+	}
+}
+
+func (r *FSReader) Read() ([]*file.File, error) {
+	entries, err := iofs.ReadDir(r.FS, r.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+
+	return reader.Filter(names, r.Ignore, r.Strict, r.buildContentFunc)
+}
+
+func (r *FSReader) buildContentFunc(name string) file.ContentFunc {
+	return func() ([]byte, error) {
+		return iofs.ReadFile(r.FS, path.Join(r.Path, name))
+	}
+}
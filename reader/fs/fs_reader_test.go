@@ -0,0 +1,76 @@
+package fs
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/suite"
+
+	readerpkg "github.com/netw00rk/sqltractor/reader"
+)
+
+var mapFS = fstest.MapFS{
+	"migrations/001_migrationfile.up.sql":   {Data: nil},
+	"migrations/001_migrationfile.down.sql": {Data: nil},
+	"migrations/002_migrationfile.up.sql":   {Data: []byte("test")},
+	"migrations/README.md":                  {Data: []byte("docs")},
+	"migrations/seeds/003_seed.up.sql":      {Data: nil},
+	"004_root.up.sql":                       {Data: nil},
+}
+
+type FSReaderTestSuite struct {
+	suite.Suite
+}
+
+func (s *FSReaderTestSuite) TestReadFiles() {
+	reader := NewFSReader(mapFS, "migrations")
+	reader.Strict = true
+	files, err := reader.Read()
+	s.Nil(err)
+	s.Equal(3, len(files))
+
+	content, err := files[2].Content()
+	s.Nil(err)
+	s.Equal("002_migrationfile.up.sql", files[2].FileName)
+	s.Equal([]byte("test"), content)
+}
+
+func (s *FSReaderTestSuite) TestLazyContent() {
+	fsys := fstest.MapFS{"001_init.up.sql": {Data: []byte("before")}}
+
+	files, err := NewFSReader(fsys, "").Read()
+	s.Nil(err)
+	s.Equal(1, len(files))
+
+	fsys["001_init.up.sql"] = &fstest.MapFile{Data: []byte("after")}
+	content, err := files[0].Content()
+	s.Nil(err)
+	s.Equal([]byte("after"), content)
+}
+
+func (s *FSReaderTestSuite) TestStrict() {
+	reader := NewFSReader(fstest.MapFS{
+		"001_init.up.sql":  {},
+		"001_init.upp.sql": {},
+	}, ".")
+
+	files, err := reader.Read()
+	s.Nil(err)
+	s.Equal(1, len(files))
+
+	reader.Strict = true
+	_, err = reader.Read()
+	invalid, ok := err.(readerpkg.InvalidFilesError)
+	s.True(ok)
+	s.Equal(1, len(invalid))
+	s.Equal("001_init.upp.sql", invalid[0].FileName)
+}
+
+func (s *FSReaderTestSuite) TestMissingPath() {
+	_, err := NewFSReader(mapFS, "missing").Read()
+	s.NotNil(err)
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(FSReaderTestSuite))
+}
@@ -0,0 +1,44 @@
+package function
+
+import (
+	"github.com/netw00rk/sqltractor/reader"
+	"github.com/netw00rk/sqltractor/tractor/migration/direction"
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
+)
+
+// FunctionReader supplies migrations written in Go,
+// optionally next to the files of another reader.
+type FunctionReader struct {
+	base  reader.Reader
+	files []*file.File
+}
+
+// NewFunctionReader returns a reader of the registered Go migrations
+// and of all files of base, which may be nil.
+func NewFunctionReader(base reader.Reader) *FunctionReader {
+	return &FunctionReader{base: base}
+}
+
+// Add registers a Go migration, down may be nil for irreversible migrations.
+func (r *FunctionReader) Add(version uint64, name string, up, down file.MigrationFunc) *FunctionReader {
+	if up != nil {
+		r.files = append(r.files, file.NewFuncFile(version, name, direction.Up, up))
+	}
+	if down != nil {
+		r.files = append(r.files, file.NewFuncFile(version, name, direction.Down, down))
+	}
+	return r
+}
+
+func (r *FunctionReader) Read() ([]*file.File, error) {
+	files := make([]*file.File, 0, len(r.files))
+	if r.base != nil {
+		baseFiles, err := r.base.Read()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, baseFiles...)
+	}
+
+	return append(files, r.files...), nil
+}
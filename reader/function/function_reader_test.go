@@ -0,0 +1,56 @@
+package function
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/netw00rk/sqltractor/reader/memory"
+	"github.com/netw00rk/sqltractor/tractor/migration/direction"
+)
+
+var files map[string][]byte = map[string][]byte{
+	"001_migrationfile.up.sql":   nil,
+	"001_migrationfile.down.sql": nil,
+}
+
+func noop(ctx context.Context, conn interface{}) error {
+	return nil
+}
+
+type FunctionReaderTestSuite struct {
+	suite.Suite
+}
+
+func (s *FunctionReaderTestSuite) TestReadFiles() {
+	reader := NewFunctionReader(memory.NewMemoryReader(files)).
+		Add(2, "backfill", noop, noop).
+		Add(3, "irreversible", noop, nil)
+
+	files, err := reader.Read()
+	s.Nil(err)
+	s.Equal(5, len(files))
+
+	funcs := 0
+	for _, file := range files {
+		if file.Func != nil {
+			funcs++
+		}
+		if file.Version == 3 {
+			s.Equal(direction.Up, file.Direction)
+			s.Equal("003_irreversible.up.go", file.FileName)
+		}
+	}
+	s.Equal(3, funcs)
+}
+
+func (s *FunctionReaderTestSuite) TestReadWithoutBase() {
+	files, err := NewFunctionReader(nil).Add(1, "init", noop, noop).Read()
+	s.Nil(err)
+	s.Equal(2, len(files))
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(FunctionReaderTestSuite))
+}
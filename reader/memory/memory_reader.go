@@ -2,30 +2,35 @@ package memory
 
 import (
 	"errors"
+	"sort"
 
+	"github.com/netw00rk/sqltractor/reader"
 	"github.com/netw00rk/sqltractor/tractor/migration/file"
 )
 
 type MemoryReader struct {
+	// Strict makes Read fail with a reader.InvalidFilesError
+	// instead of skipping files it can not parse
+	Strict bool
+
 	files map[string][]byte
 }
 
 func NewMemoryReader(files map[string][]byte) *MemoryReader {
-	return &MemoryReader{files}
+	return &MemoryReader{files: files}
 }
 
 func (r *MemoryReader) Read() ([]*file.File, error) {
-	result := make([]*file.File, 0, len(r.files))
+	names := make([]string, 0, len(r.files))
 	for k := range r.files {
-		if file, err := file.NewFile(k, r.buildContentFunc(k)); err == nil {
-			result = append(result, file)
-		}
+		names = append(names, k)
 	}
+	sort.Strings(names)
 
-	return result, nil
+	return reader.Filter(names, nil, r.Strict, r.buildContentFunc)
 }
 
-func (r *MemoryReader) buildContentFunc(name string) func() ([]byte, error) {
+func (r *MemoryReader) buildContentFunc(name string) file.ContentFunc {
 	return func() ([]byte, error) {
 		if content, ok := r.files[name]; ok {
 			return content, nil
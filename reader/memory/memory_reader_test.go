@@ -5,6 +5,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/suite"
+
+	readerpkg "github.com/netw00rk/sqltractor/reader"
 )
 
 var files map[string][]byte = map[string][]byte{
@@ -34,6 +36,26 @@ func (s *MemoryReaderTestSuite) TestReadFiles() {
 	s.Equal([]byte("test"), content, files[2].FileName)
 }
 
+func (s *MemoryReaderTestSuite) TestStrict() {
+	reader := NewMemoryReader(map[string][]byte{
+		"001_init.up.sql":  nil,
+		"001_init.upp.sql": nil,
+		"01a_x.up.sql":     nil,
+	})
+
+	files, err := reader.Read()
+	s.Nil(err)
+	s.Equal(1, len(files))
+
+	reader.Strict = true
+	_, err = reader.Read()
+	invalid, ok := err.(readerpkg.InvalidFilesError)
+	s.True(ok)
+	s.Equal(2, len(invalid))
+	s.Equal("001_init.upp.sql", invalid[0].FileName)
+	s.Contains(err.Error(), "01a_x.up.sql")
+}
+
 func Test(t *testing.T) {
 	suite.Run(t, new(MemoryReaderTestSuite))
 }
@@ -0,0 +1,111 @@
+package reader
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
+)
+
+// MultiReader merges the migrations of several named sources, like the
+// migration directories of the modules an application is built from.
+type MultiReader struct {
+	sources []*source
+}
+
+type source struct {
+	name   string
+	reader Reader
+}
+
+// NewMultiReader returns a reader without sources, see Add.
+func NewMultiReader() *MultiReader {
+	return &MultiReader{}
+}
+
+// Add registers reader as the source name, which is set as
+// Source of its files unless they have one already.
+func (r *MultiReader) Add(name string, reader Reader) *MultiReader {
+	r.sources = append(r.sources, &source{name: name, reader: reader})
+	return r
+}
+
+// Read returns the files of all sources ordered by version and file name.
+// It fails with a CollisionError if several sources hold the same version.
+func (r *MultiReader) Read() ([]*file.File, error) {
+	files := make([]*file.File, 0)
+	sources := make(map[uint64]string)
+	collisions := make(map[uint64]*Collision)
+	for _, s := range r.sources {
+		sourceFiles, err := s.reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", s.name, err)
+		}
+
+		for _, f := range sourceFiles {
+			if f.Source == "" {
+				f.Source = s.name
+			}
+
+			if owner, ok := sources[f.Version]; !ok {
+				sources[f.Version] = s.name
+			} else if owner != s.name && collisions[f.Version] == nil {
+				collisions[f.Version] = &Collision{Version: f.Version}
+			}
+			files = append(files, f)
+		}
+	}
+
+	sort.Sort(byVersion(files))
+
+	if len(collisions) > 0 {
+		err := make(CollisionError, 0, len(collisions))
+		for _, f := range files {
+			if collision, ok := collisions[f.Version]; ok {
+				if len(collision.Files) == 0 {
+					err = append(err, collision)
+				}
+				collision.Files = append(collision.Files, f)
+			}
+		}
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// Collision is a version held by several sources of a MultiReader
+type Collision struct {
+	Version uint64
+
+	// all files of the version, their Source tells where they came from
+	Files []*file.File
+}
+
+// CollisionError is returned by MultiReader, listing every
+// version held by several sources in order.
+type CollisionError []*Collision
+
+func (e CollisionError) Error() string {
+	parts := make([]string, len(e))
+	for i, collision := range e {
+		names := make([]string, len(collision.Files))
+		for j, f := range collision.Files {
+			names[j] = f.Source + ": " + f.FileName
+		}
+		parts[i] = fmt.Sprintf("version %d in %s", collision.Version, strings.Join(names, ", "))
+	}
+	return "migration versions in several sources: " + strings.Join(parts, "; ")
+}
+
+type byVersion []*file.File
+
+func (b byVersion) Len() int      { return len(b) }
+func (b byVersion) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byVersion) Less(i, j int) bool {
+	if b[i].Version != b[j].Version {
+		return b[i].Version < b[j].Version
+	}
+	return b[i].FileName < b[j].FileName
+}
@@ -0,0 +1,93 @@
+package reader
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
+)
+
+// namesReader reads files of the given names without content
+type namesReader []string
+
+func (r namesReader) Read() ([]*file.File, error) {
+	files := make([]*file.File, 0, len(r))
+	for _, name := range r {
+		f, err := file.NewFile(name, func() ([]byte, error) { return nil, nil })
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+type failingReader struct{}
+
+func (r failingReader) Read() ([]*file.File, error) {
+	return nil, errors.New("no such directory")
+}
+
+type MultiReaderTestSuite struct {
+	suite.Suite
+}
+
+func (s *MultiReaderTestSuite) TestReadFiles() {
+	reader := NewMultiReader().
+		Add("app", namesReader{"003_orders.up.sql", "001_users.up.sql", "001_users.down.sql"}).
+		Add("billing", namesReader{"002_invoices.up.sql", "002_invoices.down.sql"})
+
+	files, err := reader.Read()
+	s.Nil(err)
+
+	var tests = []struct {
+		fileName string
+		source   string
+	}{
+		{"001_users.down.sql", "app"},
+		{"001_users.up.sql", "app"},
+		{"002_invoices.down.sql", "billing"},
+		{"002_invoices.up.sql", "billing"},
+		{"003_orders.up.sql", "app"},
+	}
+
+	s.Equal(len(tests), len(files))
+	for i, test := range tests {
+		s.Equal(test.fileName, files[i].FileName)
+		s.Equal(test.source, files[i].Source)
+	}
+}
+
+func (s *MultiReaderTestSuite) TestCollision() {
+	reader := NewMultiReader().
+		Add("app", namesReader{"001_users.up.sql", "002_orders.up.sql", "003_items.up.sql"}).
+		Add("billing", namesReader{"002_invoices.up.sql", "002_invoices.down.sql"}).
+		Add("audit", namesReader{"003_log.up.sql"})
+
+	_, err := reader.Read()
+	collisions, ok := err.(CollisionError)
+	s.True(ok)
+	s.Equal(2, len(collisions))
+
+	s.Equal(uint64(2), collisions[0].Version)
+	s.Equal(3, len(collisions[0].Files))
+	s.Equal(uint64(3), collisions[1].Version)
+	s.Equal("app", collisions[1].Files[0].Source)
+	s.Equal("audit", collisions[1].Files[1].Source)
+	s.Contains(err.Error(), "version 2 in billing: 002_invoices.down.sql, billing: 002_invoices.up.sql, app: 002_orders.up.sql")
+}
+
+func (s *MultiReaderTestSuite) TestError() {
+	_, err := NewMultiReader().
+		Add("app", namesReader{"001_users.up.sql"}).
+		Add("billing", failingReader{}).
+		Read()
+	s.NotNil(err)
+	s.Contains(err.Error(), "billing")
+}
+
+func TestMultiReader(t *testing.T) {
+	suite.Run(t, new(MultiReaderTestSuite))
+}
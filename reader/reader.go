@@ -1,8 +1,30 @@
 package reader
 
-import "github.com/netw00rk/sqltractor/tractor/migration/file"
+import (
+	"strings"
+
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
+)
 
 type Reader interface {
 	// function that reader migration files, returns slice of File struct or error
 	Read() ([]*file.File, error)
 }
+
+// InvalidFile is a file a reader could not parse as migration file
+type InvalidFile struct {
+	FileName string
+	Err      error
+}
+
+// InvalidFilesError is returned by readers in strict mode,
+// listing every file they could not parse and why.
+type InvalidFilesError []*InvalidFile
+
+func (e InvalidFilesError) Error() string {
+	parts := make([]string, len(e))
+	for i, f := range e {
+		parts[i] = f.FileName + ": " + f.Err.Error()
+	}
+	return "invalid migration files: " + strings.Join(parts, ", ")
+}
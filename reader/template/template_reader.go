@@ -0,0 +1,82 @@
+// Package template renders migrations as Go text/template before they run.
+package template
+
+import (
+	"bytes"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/netw00rk/sqltractor/reader"
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
+)
+
+// Marker opts a migration into rendering if it is part of the file
+// name, like 001_create_schema.up.sql.tmpl or 001_create_schema.up.tmpl.sql
+const Marker = ".tmpl"
+
+// Header opts a migration into rendering if it starts its first line
+const Header = "-- sqltractor:template"
+
+// TemplateReader renders the templated migrations of another reader with
+// Vars, e.g. CREATE SCHEMA {{.schema}}. All other files are left as they are.
+// Rendering happens when the content is read, so drivers execute and
+// checksums are computed on the rendered output. Referencing a variable
+// that is not in Vars fails.
+type TemplateReader struct {
+	Vars map[string]string
+
+	base reader.Reader
+}
+
+func NewTemplateReader(base reader.Reader, vars map[string]string) *TemplateReader {
+	return &TemplateReader{
+		Vars: vars,
+		base: base,
+	}
+}
+
+func (r *TemplateReader) Read() ([]*file.File, error) {
+	files, err := r.base.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		if f.Func == nil {
+			f.ContentFunc = r.buildContentFunc(f.FileName, f.ContentFunc)
+		}
+	}
+	return files, nil
+}
+
+// Templated reports whether the migration fileName with content is rendered
+func Templated(fileName string, content []byte) bool {
+	return strings.HasSuffix(fileName, Marker) ||
+		strings.Contains(fileName, Marker+".") ||
+		bytes.HasPrefix(content, []byte(Header))
+}
+
+func (r *TemplateReader) buildContentFunc(name string, contentFunc file.ContentFunc) file.ContentFunc {
+	return func() ([]byte, error) {
+		content, err := contentFunc()
+		if err != nil || !Templated(name, content) {
+			return content, err
+		}
+
+		tmpl, err := texttemplate.New(name).Option("missingkey=error").Parse(string(content))
+		if err != nil {
+			return nil, err
+		}
+
+		vars := r.Vars
+		if vars == nil {
+			vars = map[string]string{}
+		}
+
+		var rendered bytes.Buffer
+		if err := tmpl.Execute(&rendered, vars); err != nil {
+			return nil, err
+		}
+		return rendered.Bytes(), nil
+	}
+}
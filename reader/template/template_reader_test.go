@@ -0,0 +1,74 @@
+package template
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/netw00rk/sqltractor/reader/memory"
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
+)
+
+var files map[string][]byte = map[string][]byte{
+	"001_schema.up.sql.tmpl":   []byte("CREATE SCHEMA {{.schema}} AUTHORIZATION {{.role}};"),
+	"001_schema.down.tmpl.sql": []byte("DROP SCHEMA {{.schema}};"),
+	"002_grant.up.sql":         []byte(Header + "\nGRANT USAGE ON SCHEMA {{.schema}} TO {{.role}};"),
+	"003_plain.up.sql":         []byte("SELECT '{{.schema}}';"),
+}
+
+type TemplateReaderTestSuite struct {
+	suite.Suite
+}
+
+func (s *TemplateReaderTestSuite) read(vars map[string]string) map[string]*file.File {
+	files, err := NewTemplateReader(memory.NewMemoryReader(files), vars).Read()
+	s.Nil(err)
+	s.Equal(4, len(files))
+
+	byName := make(map[string]*file.File)
+	for _, f := range files {
+		byName[f.FileName] = f
+	}
+	return byName
+}
+
+func (s *TemplateReaderTestSuite) TestRender() {
+	files := s.read(map[string]string{"schema": "tenant_a", "role": "app"})
+
+	var tests = []struct {
+		fileName string
+		expected string
+	}{
+		{"001_schema.up.sql.tmpl", "CREATE SCHEMA tenant_a AUTHORIZATION app;"},
+		{"001_schema.down.tmpl.sql", "DROP SCHEMA tenant_a;"},
+		{"002_grant.up.sql", Header + "\nGRANT USAGE ON SCHEMA tenant_a TO app;"},
+		{"003_plain.up.sql", "SELECT '{{.schema}}';"},
+	}
+
+	for _, test := range tests {
+		content, err := files[test.fileName].Content()
+		s.Nil(err, test.fileName)
+		s.Equal(test.expected, string(content), test.fileName)
+	}
+}
+
+func (s *TemplateReaderTestSuite) TestChecksum() {
+	a, err := s.read(map[string]string{"schema": "tenant_a", "role": "app"})["001_schema.up.sql.tmpl"].Checksum()
+	s.Nil(err)
+	b, err := s.read(map[string]string{"schema": "tenant_b", "role": "app"})["001_schema.up.sql.tmpl"].Checksum()
+	s.Nil(err)
+	s.NotEqual(a, b)
+}
+
+func (s *TemplateReaderTestSuite) TestMissingVar() {
+	_, err := s.read(map[string]string{"schema": "tenant_a"})["001_schema.up.sql.tmpl"].Content()
+	s.NotNil(err)
+	s.Contains(err.Error(), "role")
+
+	_, err = s.read(nil)["001_schema.down.tmpl.sql"].Content()
+	s.NotNil(err)
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(TemplateReaderTestSuite))
+}
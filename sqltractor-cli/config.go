@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/netw00rk/sqltractor/config"
+)
+
+// applyConfig fills the flags not given on the command line from the
+// environment selected by -env, or the default one, of the configuration file
+func applyConfig() error {
+	fileName := *configFile
+	if fileName == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		fileName, err = config.Find(cwd)
+		if err == config.ErrNotFound && *env == "" {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	cfg, err := config.Load(fileName)
+	if err != nil {
+		return err
+	}
+
+	if *env == "" && cfg.Default == "" {
+		return nil
+	}
+
+	environment, err := cfg.Environment(*env)
+	if err != nil {
+		return err
+	}
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	if !set["url"] && environment.URL != "" {
+		if *connectionUrl, err = environment.DatabaseURL(); err != nil {
+			return err
+		}
+	}
+
+	if !set["path"] {
+		paths = append(paths, environment.Paths...)
+	}
+
+	if !set["version-table"] && environment.VersionTable != "" {
+		*versionTable = environment.VersionTable
+	}
+
+	for key, value := range environment.Vars {
+		if _, ok := templateVars[key]; !ok {
+			templateVars[key] = value
+		}
+	}
+
+	if !set["lock"] && environment.Lock.Mode != "" {
+		*lockMode = environment.Lock.Mode
+	}
+
+	for _, duration := range []struct {
+		flag  string
+		value string
+		dest  *time.Duration
+	}{
+		{"lock-timeout", environment.Lock.Timeout, lockTimeout},
+		{"lock-ttl", environment.Lock.TTL, lockTTL},
+	} {
+		if set[duration.flag] || duration.value == "" {
+			continue
+		}
+		if *duration.dest, err = time.ParseDuration(duration.value); err != nil {
+			return fmt.Errorf("Unable to parse lock %s of environment: %s", duration.flag, err)
+		}
+	}
+
+	return nil
+}
+
+// varsFlag collects the variables of templated migrations given by -var key=value
+type varsFlag map[string]string
+
+func (v varsFlag) String() string {
+	pairs := make([]string, 0, len(v))
+	for key, value := range v {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (v varsFlag) Set(pair string) error {
+	parts := strings.SplitN(pair, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("Unable to parse -var %s, use key=value.", pair)
+	}
+	v[parts[0]] = parts[1]
+	return nil
+}
+
+// pathsFlag collects the migration directories given by -path
+type pathsFlag []string
+
+func (p *pathsFlag) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *pathsFlag) Set(path string) error {
+	*p = append(*p, path)
+	return nil
+}
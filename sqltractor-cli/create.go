@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	reader "github.com/netw00rk/sqltractor/reader/file"
+)
+
+var versionPrefixRegex = regexp.MustCompile(`^([0-9]+)_`)
+
+var errInvalidName = errors.New("Please specify a valid name.")
+
+// migrationTemplate is the data a custom template for create is rendered with
+type migrationTemplate struct {
+	Version   string
+	Name      string
+	Direction string
+}
+
+// createMigration writes an up and a down file for name to dir and returns their names.
+// The version follows the highest existing one, zero-padded like the existing files,
+// or is the current UTC time if timestamp is set. The files are empty unless
+// templateFile is given, which is rendered as text/template with migrationTemplate.
+func createMigration(dir, name, ext string, timestamp bool, templateFile string) ([]string, error) {
+	name = strings.Join(strings.Fields(name), "_")
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return nil, errInvalidName
+	}
+
+	var tmpl *template.Template
+	if templateFile != "" {
+		var err error
+		if tmpl, err = template.ParseFiles(templateFile); err != nil {
+			return nil, err
+		}
+	}
+
+	version, err := nextVersion(dir, timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	created := make([]string, 0, 2)
+	for _, direction := range []string{"up", "down"} {
+		content := new(bytes.Buffer)
+		if tmpl != nil {
+			if err := tmpl.Execute(content, migrationTemplate{version, name, direction}); err != nil {
+				return created, err
+			}
+		}
+
+		fileName := fmt.Sprintf("%s_%s.%s.%s", version, name, direction, ext)
+		if err := writeNewFile(filepath.Join(dir, fileName), content.Bytes()); err != nil {
+			return created, err
+		}
+		created = append(created, fileName)
+	}
+
+	return created, nil
+}
+
+// nextVersion is the version after the highest one in dir, zero-padded
+// to the widest existing version and to at least 3 digits
+func nextVersion(dir string, timestamp bool) (string, error) {
+	if timestamp {
+		return time.Now().UTC().Format("20060102150405"), nil
+	}
+
+	files, err := reader.NewFileReader(dir).Read()
+	if err != nil {
+		return "", err
+	}
+
+	width := 3
+	var latest uint64
+	for _, f := range files {
+		if f.Version > latest {
+			latest = f.Version
+		}
+		if matches := versionPrefixRegex.FindStringSubmatch(f.FileName); matches != nil && len(matches[1]) > width {
+			width = len(matches[1])
+		}
+	}
+
+	version := strconv.FormatUint(latest+1, 10)
+	if len(version) < width {
+		version = strings.Repeat("0", width-len(version)) + version
+	}
+	return version, nil
+}
+
+// writeNewFile fails instead of overwriting an existing file
+func writeNewFile(path string, content []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// migrationExtension is the file extension of migrations for the driver of rawurl
+func migrationExtension(rawurl string) string {
+	if strings.HasPrefix(rawurl, "cassandra://") {
+		return "cql"
+	}
+	return "sql"
+}
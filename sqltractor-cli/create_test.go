@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CreateTestSuite struct {
+	suite.Suite
+	path string
+}
+
+func (s *CreateTestSuite) SetupTest() {
+	s.path, _ = ioutil.TempDir("/tmp", "TestCreate")
+}
+
+func (s *CreateTestSuite) TearDownTest() {
+	os.RemoveAll(s.path)
+}
+
+func (s *CreateTestSuite) write(names ...string) {
+	for _, name := range names {
+		ioutil.WriteFile(path.Join(s.path, name), nil, 0644)
+	}
+}
+
+func (s *CreateTestSuite) TestNextVersionEmptyDir() {
+	version, err := nextVersion(s.path, false)
+	s.Nil(err)
+	s.Equal("001", version)
+}
+
+func (s *CreateTestSuite) TestNextVersionZeroPadded() {
+	s.write("001_init.up.sql", "001_init.down.sql", "009_users.up.sql", "README.md")
+
+	version, err := nextVersion(s.path, false)
+	s.Nil(err)
+	s.Equal("010", version)
+
+	s.write("00042_wide.up.sql")
+	version, err = nextVersion(s.path, false)
+	s.Nil(err)
+	s.Equal("00043", version)
+
+	s.write("99999_last.up.sql")
+	version, err = nextVersion(s.path, false)
+	s.Nil(err)
+	s.Equal("100000", version)
+}
+
+func (s *CreateTestSuite) TestNextVersionTimestamp() {
+	s.write("001_init.up.sql")
+
+	before := time.Now().UTC().Truncate(time.Second)
+	version, err := nextVersion(s.path, true)
+	s.Nil(err)
+	s.Equal(14, len(version))
+
+	created, err := time.Parse("20060102150405", version)
+	s.Nil(err)
+	s.False(created.Before(before))
+	s.False(created.After(time.Now().UTC()))
+}
+
+func (s *CreateTestSuite) TestCreateMigration() {
+	s.write("001_init.up.sql", "001_init.down.sql")
+
+	created, err := createMigration(s.path, " add  users ", "sql", false, "")
+	s.Nil(err)
+	s.Equal([]string{"002_add_users.up.sql", "002_add_users.down.sql"}, created)
+
+	_, err = createMigration(s.path, "a/b", "sql", false, "")
+	s.Equal(errInvalidName, err)
+}
+
+func TestCreateTestSuite(t *testing.T) {
+	suite.Run(t, new(CreateTestSuite))
+}
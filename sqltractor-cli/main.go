@@ -2,18 +2,25 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"net/url"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/fatih/color"
 
+	readerpkg "github.com/netw00rk/sqltractor/reader"
 	reader "github.com/netw00rk/sqltractor/reader/file"
+	templatereader "github.com/netw00rk/sqltractor/reader/template"
 	"github.com/netw00rk/sqltractor/tractor"
+	"github.com/netw00rk/sqltractor/tractor/migration"
 	"github.com/netw00rk/sqltractor/tractor/migration/direction"
 	"github.com/netw00rk/sqltractor/tractor/migration/file"
 
@@ -25,114 +32,447 @@ import (
 )
 
 var connectionUrl = flag.String("url", os.Getenv("MIGRATE_URL"), "")
-var path = flag.String("path", "", "")
+var paths = make(pathsFlag, 0)
+var dryRun = flag.Bool("dry-run", false, "")
+var ignoreDrift = flag.Bool("ignore-drift", false, "")
+var outOfOrder = flag.Bool("out-of-order", false, "")
+var lockTimeout = flag.Duration("lock-timeout", 30*time.Second, "")
+var lockTTL = flag.Duration("lock-ttl", 5*time.Minute, "")
+var force = flag.Bool("force", false, "")
+var lockMode = flag.String("lock", "native", "")
+var strict = flag.Bool("strict", false, "")
+var conflicts = flag.String("conflicts", "warn", "")
+var timestamp = flag.Bool("timestamp", false, "")
+var templateFile = flag.String("template", "", "")
+var confirm = flag.Bool("confirm", false, "")
+var format = flag.String("format", "text", "")
+var configFile = flag.String("config", "", "")
+var env = flag.String("env", "", "")
+var versionTable = flag.String("version-table", "", "")
+var templateVars = make(varsFlag)
 
 func main() {
+	flag.Var(&paths, "path", "")
+	flag.Var(templateVars, "var", "")
 	flag.Parse()
+	driver.ToolVersion = "sqltractor-cli " + Version
+
+	var err error
+	if out, err = newOutput(*format); err != nil {
+		fmt.Println(err)
+		os.Exit(exitUsage)
+	}
+
 	command := flag.Arg(0)
 	if command == "" || command == "help" {
 		printHelpCmd()
 		os.Exit(0)
 	}
 
-	if *path == "" {
-		var err error
-		if *path, err = os.Getwd(); err != nil {
-			fmt.Println("Please specify path")
-			os.Exit(1)
+	if err := applyConfig(); err != nil {
+		fail(exitUsage, err)
+	}
+
+	// commands without a migration plan must never run on a dry run
+	switch command {
+	case "create", "drop", "force", "baseline", "unlock":
+		if *dryRun {
+			usage(fmt.Sprintf("-dry-run is not supported by %s.", command))
 		}
 	}
 
+	if len(paths) == 0 {
+		cwd, err := os.Getwd()
+		if err != nil {
+			usage("Please specify path")
+		}
+		paths = append(paths, cwd)
+	}
+
+	// create and squash write files, so they need to know where
+	path := paths[0]
+	if len(paths) > 1 && (command == "create" || command == "squash") {
+		usage(fmt.Sprintf("%s works on a single -path.", command))
+	}
+
+	// create and squash only work on the migration files, all other commands
+	// connect up front, so that an unreachable database is told from other failures
 	driver, err := getDriver(*connectionUrl)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	if command != "create" && command != "squash" {
+		if err != nil {
+			fail(exitUsage, err)
+		}
+		if err := setVersionTable(driver, *versionTable); err != nil {
+			fail(exitUsage, err)
+		}
+		if err := setLockMode(driver, *lockMode); err != nil {
+			fail(exitUsage, err)
+		}
+		if err := driver.Initialize(); err != nil {
+			fail(exitConnection, err)
+		}
+	}
+
+	var migrationReader readerpkg.Reader = newFileReader(path)
+	if len(paths) > 1 {
+		multiReader := readerpkg.NewMultiReader()
+		for _, p := range paths {
+			multiReader.Add(p, newFileReader(p))
+		}
+		migrationReader = multiReader
 	}
 
 	tractor := &tractor.SqlTractor{
 		Driver: driver,
-		Reader: reader.NewFileReader(*path),
+		Reader: migrationReader,
+
+		IgnoreDrift: *ignoreDrift,
+		OutOfOrder:  *outOfOrder,
+		LockTimeout: *lockTimeout,
+		LockTTL:     *lockTTL,
+	}
+
+	// squash writes the content it reads, so templates are kept as they are
+	if command != "squash" {
+		tractor.Reader = templatereader.NewTemplateReader(migrationReader, templateVars)
+	}
+
+	switch *conflicts {
+	case "error":
+		tractor.ManagerOptions.FailOnConflict = true
+	case "warn":
+		tractor.ManagerOptions.Warn = func(conflict *migration.Conflict) {
+			if out.structured() {
+				out.emit(conflictRecord{"conflict", conflict.Version, conflict.Reason, conflict.FileNames})
+				return
+			}
+			color.New(color.FgYellow).Printf("version %d: %s\n", conflict.Version, conflict)
+		}
+	default:
+		usage("Unable to parse -conflicts, use warn or error.")
 	}
 
 	switch command {
+	case "create":
+		created, err := createMigration(path, strings.Join(flag.Args()[1:], " "), migrationExtension(*connectionUrl), *timestamp, *templateFile)
+		for _, fileName := range created {
+			if out.structured() {
+				out.emit(pathRecord{"created", filepath.Join(path, fileName)})
+			} else {
+				fmt.Printf("created %s\n", filepath.Join(path, fileName))
+			}
+		}
+		if err == errInvalidName {
+			fail(exitUsage, err)
+		} else if err != nil {
+			fail(exitFailure, err)
+		}
+
 	case "migrate":
 		relativeN, err := strconv.Atoi(flag.Arg(1))
 		if err != nil {
-			fmt.Println("Unable to parse param <n>.")
-			os.Exit(1)
+			usage("Unable to parse param <n>.")
 		}
 
-		timerStart := time.Now()
-		for r := range tractor.MigrateAsync(relativeN) {
-			if r.Error != nil {
-				printFile(r.File, err)
-				os.Exit(1)
-			}
-			printFile(r.File, nil)
+		if *dryRun {
+			printPlan(tractor.PlanMigrate(context.Background(), relativeN))
+		} else {
+			runAndPrint(tractor.MigrateAsync(relativeN))
 		}
-		printTimer(timerStart)
 
 	case "goto":
-		toVersion, err := strconv.Atoi(flag.Arg(1))
-		if err != nil || toVersion < 0 {
-			fmt.Println("Unable to parse param <v>.")
-			os.Exit(1)
-		}
-
-		currentVersion, err := tractor.Version()
+		toVersion, err := strconv.ParseUint(flag.Arg(1), 10, 64)
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			usage("Unable to parse param <v>.")
 		}
 
-		relativeN := toVersion - int(currentVersion)
+		if *dryRun {
+			printPlan(tractor.PlanGoto(context.Background(), toVersion))
+		} else {
+			runAndPrint(tractor.GotoAsync(toVersion))
+		}
 
-		timerStart := time.Now()
-		for r := range tractor.MigrateAsync(relativeN) {
-			if r.Error != nil {
-				printFile(r.File, err)
-				os.Exit(1)
+	case "redo":
+		n := 1
+		if flag.Arg(1) != "" {
+			var err error
+			if n, err = strconv.Atoi(flag.Arg(1)); err != nil || n < 1 {
+				usage("Unable to parse param <n>.")
 			}
-			printFile(r.File, nil)
 		}
-		printTimer(timerStart)
+
+		if *dryRun {
+			printPlan(tractor.PlanRedo(context.Background(), n))
+		} else {
+			runAndPrint(tractor.RedoAsync(n))
+		}
 
 	case "up":
-		timerStart := time.Now()
-		for r := range tractor.UpAsync() {
-			if r.Error != nil {
-				printFile(r.File, err)
-				os.Exit(1)
+		if *dryRun {
+			printPlan(tractor.PlanUp(context.Background()))
+			break
+		}
+
+		if *outOfOrder {
+			plan, err := tractor.PlanUp(context.Background())
+			if err != nil {
+				fail(exitCode(err), err)
 			}
-			printFile(r.File, nil)
+			printOutOfOrder(plan)
 		}
-		printTimer(timerStart)
+
+		runAndPrint(tractor.UpAsync())
 
 	case "down":
-		timerStart := time.Now()
-		for r := range tractor.DownAsync() {
-			if r.Error != nil {
-				printFile(r.File, err)
-				os.Exit(1)
+		if *dryRun {
+			printPlan(tractor.PlanDown(context.Background()))
+		} else {
+			runAndPrint(tractor.DownAsync())
+		}
+
+	case "history":
+		records, err := tractor.History(context.Background())
+		if err != nil {
+			fail(exitCode(err), err)
+		}
+
+		for _, r := range records {
+			if out.structured() {
+				out.emit(historyRecord{"history", r.Version, r.Name, r.AppliedAt, int64(r.Duration), r.Checksum, r.AppliedBy, r.ToolVersion})
+				continue
 			}
-			printFile(r.File, nil)
+			fmt.Printf("%d %s applied at %s in %s by %s (%s), sha256 %s\n",
+				r.Version, r.Name, r.AppliedAt.Format(time.RFC3339), r.Duration, r.AppliedBy, r.ToolVersion, r.Checksum)
+		}
+
+	case "validate":
+		drift, err := tractor.Validate(context.Background())
+		if err != nil {
+			fail(exitCode(err), err)
+		}
+		printDrift(drift)
+
+	case "drop":
+		if !*confirm {
+			usage("drop removes every table of the database, add -confirm to proceed.")
+		}
+
+		if err := tractor.Drop(context.Background()); err != nil {
+			fail(exitCode(err), err)
+		}
+		printMessage("drop", 0, "dropped the database schema, version is 0")
+
+	case "force":
+		version, err := strconv.ParseUint(flag.Arg(1), 10, 64)
+		if err != nil {
+			usage("Unable to parse param <v>.")
+		}
+
+		if err := tractor.Force(context.Background(), version); err != nil {
+			fail(exitCode(err), err)
+		}
+		printMessage("force", version, fmt.Sprintf("forced clean version %d", version))
+
+	case "baseline":
+		version, err := strconv.ParseUint(flag.Arg(1), 10, 64)
+		if err != nil {
+			usage("Unable to parse param <v>.")
+		}
+
+		files, err := tractor.Baseline(context.Background(), version, *force)
+		if err != nil {
+			fail(exitCode(err), err)
+		}
+
+		for _, f := range files {
+			if out.structured() {
+				out.emit(newFileRecord("baseline", f))
+			} else {
+				printFile(f, nil)
+			}
+		}
+		printMessage("baselined", version, fmt.Sprintf("\nrecorded baseline at version %d", version))
+
+	case "squash":
+		version, err := strconv.ParseUint(flag.Arg(1), 10, 64)
+		if err != nil {
+			usage("Unable to parse param <v>.")
+		}
+
+		up, down, replaced, err := tractor.Squash(version)
+		if err != nil {
+			fail(exitFailure, err)
+		}
+
+		if err := writeSquash(path, up, down, replaced, *dryRun); err != nil {
+			fail(exitFailure, err)
+		}
+
+	case "lock":
+		if flag.Arg(1) != "status" {
+			usage("Unknown lock command, use lock status.")
+		}
+
+		holder, err := tractor.LockStatus(context.Background())
+		if err != nil {
+			fail(exitCode(err), err)
+		}
+
+		if out.structured() {
+			out.emit(newLockRecord("lock", holder, *lockTTL))
+		} else if holder == nil {
+			fmt.Println("unlocked")
+		} else {
+			fmt.Printf("locked by %s\n", holder)
+			if holder.Stale(*lockTTL) {
+				color.New(color.FgRed).Printf("stale, no heartbeat for %s, remove it with unlock\n", time.Since(holder.HeartbeatAt).Round(time.Second))
+			}
+		}
+
+	case "unlock":
+		holder, err := tractor.Unlock(context.Background(), *force)
+		if err != nil {
+			fail(exitCode(err), err)
+		}
+
+		if out.structured() {
+			out.emit(newLockRecord("unlock", holder, *lockTTL))
+		} else if holder == nil {
+			fmt.Println("unlocked")
+		} else {
+			fmt.Printf("removed lock of %s\n", holder)
 		}
-		printTimer(timerStart)
 
 	case "version":
 		version, err := tractor.Version()
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			fail(exitCode(err), err)
 		}
+
+		dirtyVersion, dirty, err := tractor.Dirty(context.Background())
+		dirty = err == nil && dirty
+		if out.structured() {
+			out.emit(versionRecord{"version", version, dirty, dirtyVersion})
+			break
+		}
+
 		fmt.Println(version)
+		if dirty {
+			color.New(color.FgRed).Printf("dirty at version %d, repair the database and run force <v>\n", dirtyVersion)
+		}
+
+	case "status":
+		statuses, err := tractor.Status(context.Background())
+		if err != nil {
+			fail(exitCode(err), err)
+		}
+		printStatus(statuses)
+
+	default:
+		usage(fmt.Sprintf("Unknown command %s, see help.", command))
 	}
+
+	out.exit(0)
+}
+
+// printMessage prints the outcome of a command, or emits it as record of recordType
+func printMessage(recordType string, version uint64, message string) {
+	if out.structured() {
+		out.emit(messageRecord{recordType, version, strings.TrimSpace(message)})
+		return
+	}
+	fmt.Println(message)
+}
+
+func printDrift(drift *tractor.Drift) {
+	if drift.Empty() {
+		printMessage("validated", 0, "migration files match the recorded history")
+		return
+	}
+
+	if out.structured() {
+		for _, f := range drift.Modified {
+			out.emit(driftRecord{"drift", "modified", f.FileName, f.Version})
+		}
+		for _, r := range drift.Missing {
+			out.emit(driftRecord{"drift", "missing", "", r.Version})
+		}
+		for _, f := range drift.Unknown {
+			out.emit(driftRecord{"drift", "unknown", f.FileName, f.Version})
+		}
+		fail(exitFailure, drift)
+	}
+
+	c := color.New(color.FgRed)
+	for _, f := range drift.Modified {
+		c.Print("modified")
+		fmt.Printf(" %s\n", f.FileName)
+	}
+	for _, r := range drift.Missing {
+		c.Print("missing ")
+		fmt.Printf(" version %d %s\n", r.Version, r.Name)
+	}
+	for _, f := range drift.Unknown {
+		c.Print("unknown ")
+		fmt.Printf(" %s\n", f.FileName)
+	}
+	out.exit(exitFailure)
+}
+
+func printStatus(statuses []*tractor.MigrationStatus) {
+	if out.structured() {
+		for _, s := range statuses {
+			record := statusRecord{"status", s.Version, s.Name, s.HasUp, s.HasDown, s.Applied, nil, s.Missing}
+			if !s.AppliedAt.IsZero() {
+				appliedAt := s.AppliedAt
+				record.AppliedAt = &appliedAt
+			}
+			out.emit(record)
+		}
+		return
+	}
+
+	if len(statuses) == 0 {
+		fmt.Println("no migrations")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tFILES\tSTATUS\tAPPLIED AT")
+	for _, s := range statuses {
+		files := make([]string, 0, 2)
+		if s.HasUp {
+			files = append(files, "up")
+		}
+		if s.HasDown {
+			files = append(files, "down")
+		}
+		if len(files) == 0 {
+			files = append(files, "-")
+		}
+
+		state := "pending"
+		if s.Missing {
+			state = "applied, missing on disk"
+		} else if s.Applied {
+			state = "applied"
+		}
+
+		appliedAt := "-"
+		if !s.AppliedAt.IsZero() {
+			appliedAt = s.AppliedAt.Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", s.Version, s.Name, strings.Join(files, "/"), state, appliedAt)
+	}
+	w.Flush()
 }
 
 func printFile(f *file.File, err error) {
 	if err != nil {
 		c := color.New(color.FgRed)
-		c.Println(err.Error(), "\n")
+		c.Println(err.Error())
+		fmt.Println()
 		return
 	}
 
@@ -142,7 +482,135 @@ func printFile(f *file.File, err error) {
 	} else if f.Direction == direction.Down {
 		c.Print("<")
 	}
-	fmt.Printf(" %s\n", f.FileName)
+	fmt.Printf(" %s\n", sourcedName(f))
+}
+
+// sourcedName prefixes the file name with the path it was read from,
+// if migrations are read from several paths
+func sourcedName(f *file.File) string {
+	if f.Source == "" {
+		return f.FileName
+	}
+	return filepath.Join(f.Source, f.FileName)
+}
+
+func newFileReader(path string) *reader.FileReader {
+	fileReader := reader.NewFileReader(path)
+	fileReader.Strict = *strict
+	return fileReader
+}
+
+// runAndPrint prints every result and drains the channel before exiting,
+// so that the lock is released even if a migration failed
+func runAndPrint(results chan tractor.Result) {
+	timerStart := time.Now()
+	migrated := 0
+	var failed *tractor.Result
+	for r := range results {
+		if out.structured() {
+			out.emit(newResultRecord(r))
+		} else {
+			printResult(r)
+		}
+
+		if r.Error != nil && failed == nil {
+			failed = &r
+		} else if r.Error == nil && r.Phase == tractor.PhaseMigrating && r.File != nil {
+			migrated++
+		}
+	}
+
+	if out.structured() {
+		out.emit(summaryRecord{"summary", migrated, int64(time.Since(timerStart))})
+	} else {
+		printTimer(timerStart)
+	}
+
+	if failed == nil {
+		return
+	}
+
+	code := resultExitCode(*failed)
+	if out.structured() {
+		record := newErrorRecord(code, failed.Error)
+		record.Phase = failed.Phase.String()
+		record.Version = failed.Version
+		if failed.File != nil {
+			record.File = failed.File.FileName
+		}
+		out.emit(record)
+	}
+	out.exit(code)
+}
+
+func printResult(r tractor.Result) {
+	if r.Error != nil {
+		c := color.New(color.FgRed)
+		if r.File != nil {
+			c.Printf("error while %s %s:\n", r.Phase, sourcedName(r.File))
+		} else {
+			c.Printf("error while %s:\n", r.Phase)
+		}
+		printFile(nil, r.Error)
+		return
+	}
+
+	if r.Phase != tractor.PhaseMigrating || r.File == nil {
+		return
+	}
+
+	c := color.New(color.FgBlue)
+	if r.Direction == direction.Up {
+		c.Print(">")
+	} else if r.Direction == direction.Down {
+		c.Print("<")
+	}
+	fmt.Printf(" %s (%s)\n", sourcedName(r.File), r.Duration)
+
+	for _, stmt := range r.Statements {
+		if stmt.RowsAffected >= 0 {
+			fmt.Printf("    %d rows affected in %s\n", stmt.RowsAffected, stmt.Duration)
+		}
+	}
+}
+
+func printPlan(plan *tractor.Plan, err error) {
+	if err != nil {
+		fail(exitCode(err), err)
+	}
+
+	if out.structured() {
+		out.emit(planRecord{"plan", plan.Version})
+		for _, step := range plan.Steps {
+			out.emit(fileRecord{Type: "step", File: step.FileName, Version: step.Version, Direction: directionName(step.Direction), Checksum: step.Checksum, OutOfOrder: step.OutOfOrder})
+		}
+		return
+	}
+
+	fmt.Printf("current version %d\n\n", plan.Version)
+	if len(plan.Steps) == 0 {
+		fmt.Println("nothing to do")
+		return
+	}
+
+	for _, step := range plan.Steps {
+		printFile(step.File, nil)
+		fmt.Printf("  version %d, sha256 %s\n", step.Version, step.Checksum)
+		if step.OutOfOrder {
+			color.New(color.FgYellow).Println("  out of order")
+		}
+	}
+}
+
+func printOutOfOrder(plan *tractor.Plan) {
+	c := color.New(color.FgYellow)
+	for _, step := range plan.Steps {
+		if step.OutOfOrder && out.structured() {
+			out.emit(fileRecord{Type: "out_of_order", File: step.FileName, Version: step.Version, Direction: directionName(step.Direction), Checksum: step.Checksum, OutOfOrder: true})
+		} else if step.OutOfOrder {
+			c.Printf("applying %s out of order, current version is %d\n", step.FileName, plan.Version)
+		}
+	}
 }
 
 func printTimer(start time.Time) {
@@ -154,13 +622,12 @@ func printTimer(start time.Time) {
 	}
 }
 
+// getDriver picks the driver by the scheme of rawurl, which is not parsed
+// as a whole since mysql DSNs like user@tcp(host:3306)/database are no valid URLs
 func getDriver(rawurl string) (driver.Driver, error) {
-	u, err := url.Parse(rawurl)
-	if err != nil {
-		return nil, err
-	}
+	scheme := strings.SplitN(rawurl, "://", 2)[0]
 
-	switch u.Scheme {
+	switch scheme {
 	case "cassandra":
 		return cassandra.New(rawurl), nil
 	case "postgres":
@@ -171,22 +638,161 @@ func getDriver(rawurl string) (driver.Driver, error) {
 		return sqlite3.New(rawurl), nil
 	}
 
-	return nil, errors.New(fmt.Sprintf("Can't finde driver for scheme %s", u.Scheme))
+	return nil, errors.New(fmt.Sprintf("Can't finde driver for scheme %s", scheme))
+}
+
+// writeSquash writes the squashed files to dir and removes the files they replace
+func writeSquash(dir string, up, down *file.File, replaced []*file.File, dryRun bool) error {
+	for _, f := range replaced {
+		if f.FileName == up.FileName || f.FileName == down.FileName {
+			continue
+		}
+		if out.structured() {
+			out.emit(pathRecord{"removed", filepath.Join(dir, f.FileName)})
+		} else {
+			color.New(color.FgRed).Print("-")
+			fmt.Printf(" %s\n", f.FileName)
+		}
+	}
+
+	for _, f := range []*file.File{up, down} {
+		if out.structured() {
+			out.emit(pathRecord{"written", filepath.Join(dir, f.FileName)})
+		} else {
+			color.New(color.FgGreen).Print("+")
+			fmt.Printf(" %s\n", f.FileName)
+		}
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	for _, f := range []*file.File{up, down} {
+		content, err := f.Content()
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, f.FileName), content, 0644); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range replaced {
+		if f.FileName != up.FileName && f.FileName != down.FileName {
+			if err := os.Remove(filepath.Join(dir, f.FileName)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func setLockMode(d driver.Driver, rawMode string) error {
+	mode, err := driver.ParseLockMode(rawMode)
+	if err != nil {
+		return err
+	}
+
+	if selector, ok := d.(driver.LockModeSelector); ok {
+		selector.SetLockMode(mode)
+	} else if mode != driver.NativeLock {
+		return errors.New("driver supports a single lock mode")
+	}
+
+	return nil
+}
+
+func setVersionTable(d driver.Driver, name string) error {
+	if name == "" {
+		return nil
+	}
+
+	selector, ok := d.(driver.VersionTableSelector)
+	if !ok {
+		return errors.New("driver does not support naming the version table")
+	}
+
+	selector.SetVersionTable(name)
+	return nil
 }
 
 func printHelpCmd() {
 	os.Stderr.WriteString(
-		`usage: sqltractor [-path=<path>] -url=<url> <command> [<args>]
+		`usage: sqltractor [-config=<file>] [-env=<name>] [-format=text|json|ndjson] [-path=<path>]... [-version-table=<name>] [-var=<key>=<value>]... [-timestamp] [-template=<file>] [-strict] [-conflicts=warn|error]
+       [-dry-run] [-ignore-drift] [-out-of-order] [-lock=native|table] [-lock-timeout=<duration>] [-lock-ttl=<duration>] [-force] [-confirm]
+       -url=<url> <command> [<args>]
 
 Commands:
-   create <name>  Create a new migration
+   create <name>  Create a new up and down migration file
    up             Apply all -up- migrations
    down           Apply all -down- migrations
    version        Show current migration version
+   status         List every migration as applied or pending,
+                  flagging applied versions missing on disk
+   history        Show details of all applied migrations
+   validate       Compare migration files against the recorded history
    migrate <n>    Apply migrations -n|+n
    goto <v>       Migrate to version v
+   redo [n]       Roll back and re-apply the last n migrations, 1 by default
+   force <v>      Set clean version v without migrating, after a failed migration
+   squash <v>     Replace the migrations up to version v by one up and down file at v
+   baseline <v>   Record migrations up to version v as applied without running them
+   drop           Drop all tables, views and other objects, requires -confirm
+   lock status    Show the holder of the migration lock
+   unlock         Remove a stale migration lock
    help           Show this help
 
-'-path' defaults to current working directory.
+'-path' defaults to current working directory. Given several times, the migrations
+of all paths are merged, a version may only be used in one of them. create and
+squash need a single path.
+'-env' selects an environment of the configuration file sqltractor.yaml, .yml or
+.toml, found in the working directory or its parents unless given by '-config'.
+Environments set url, paths, driver options, version table, lock settings and
+template variables, flags given on the command line take precedence. Without
+'-env' the environment named by 'default' is used, if any. ${NAME} and
+${NAME:-default} in the file are replaced by environment variables.
+'-version-table' names the version table, the driver's default otherwise.
+'-var' sets a variable of templated migrations, whose file name contains .tmpl,
+like 001_schema.up.sql.tmpl, or whose first line is -- sqltractor:template.
+They are rendered with text/template before they run, e.g. CREATE SCHEMA {{.schema}},
+and fail on variables that are not set. Can be given several times and takes
+precedence over the vars of the environment.
+'-timestamp' makes create use the current UTC time as version instead of the
+next sequential one. '-template=<file>' renders the initial content of both files
+with text/template, using {{.Version}}, {{.Name}} and {{.Direction}} (up or down).
+Files for cassandra:// urls get the .cql extension, .sql otherwise.
+'-strict' fails on files in -path that are no valid migration file names,
+except for READMEs, .gitkeep and editor swap files.
+'-conflicts' warns about or fails on conflicting files: several up or down
+files of one version, up and down files of one version with different names,
+and down files without up file. Warning by default.
+'-dry-run' prints the migration plan of up, down, migrate, goto and redo
+without locking or migrating the database, and the files squash would replace.
+create, drop, force, baseline and unlock refuse to run with it.
+'-ignore-drift' lets up run although validate reports drift.
+'-out-of-order' lets up apply migrations with versions below the current one,
+which validate reports as unknown otherwise.
+'-lock' selects the database's native lock, released when its holder disconnects,
+or the lock table that records its holder, native by default.
+'-lock-timeout' is how long to wait for a lock held by another process, 30s by default.
+'-lock-ttl' is how long a lock may go without heartbeat before it counts as stale
+and is taken over, 5m by default.
+'-force' lets unlock remove a lock that is not stale, and baseline
+record migrations in a database that has applied migrations already.
+'-confirm' is required by drop, which wipes the schema regardless of the
+applied migrations, e.g. to reset development and test databases.
+'-format' prints colored text by default. json prints one array of records
+when the command finishes, ndjson prints every record on its own line as soon as
+it happens. Every record has a type, failures are records of type error.
+
+Exit codes:
+   0  success
+   1  any other failure, like drift or a dirty database
+   2  invalid arguments
+   3  the database can not be reached
+   4  the migration lock is held by another process
+   5  a migration failed
 `)
 }
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/netw00rk/sqltractor/driver"
+	"github.com/netw00rk/sqltractor/tractor"
+	"github.com/netw00rk/sqltractor/tractor/migration/direction"
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
+)
+
+// Exit codes are stable per failure class, so that scripts can tell them apart
+const (
+	exitFailure    = 1 // any other failure, like drift or a dirty database
+	exitUsage      = 2 // invalid arguments
+	exitConnection = 3 // the database can not be reached
+	exitLocked     = 4 // the lock is held by another process
+	exitMigration  = 5 // a migration failed
+)
+
+var exitClasses = map[int]string{
+	exitFailure:    "failure",
+	exitUsage:      "invalid_arguments",
+	exitConnection: "connection",
+	exitLocked:     "locked",
+	exitMigration:  "migration",
+}
+
+// output writes the records of a command in the format selected by -format.
+// text leaves printing to the command, ndjson writes one record per line as
+// soon as it is emitted and json writes all records as one array on exit.
+type output struct {
+	format  string
+	records []interface{}
+}
+
+var out = &output{format: "text"}
+
+func newOutput(format string) (*output, error) {
+	switch format {
+	case "text", "json", "ndjson":
+		return &output{format: format, records: make([]interface{}, 0)}, nil
+	}
+	return nil, fmt.Errorf("Unable to parse -format=%s, use text, json or ndjson.", format)
+}
+
+// structured reports whether commands emit records instead of printing text
+func (o *output) structured() bool {
+	return o.format != "text"
+}
+
+func (o *output) emit(record interface{}) {
+	switch o.format {
+	case "ndjson":
+		encoder(os.Stdout).Encode(record)
+	case "json":
+		o.records = append(o.records, record)
+	}
+}
+
+// exit writes the collected records in json format and exits with code
+func (o *output) exit(code int) {
+	if o.format == "json" {
+		e := encoder(os.Stdout)
+		e.SetIndent("", "  ")
+		e.Encode(o.records)
+	}
+	os.Exit(code)
+}
+
+// encoder leaves <, > and & in error messages and queries as they are
+func encoder(w io.Writer) *json.Encoder {
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+	return e
+}
+
+// fail reports err and exits with code
+func fail(code int, err error) {
+	if out.structured() {
+		out.emit(newErrorRecord(code, err))
+	} else {
+		fmt.Println(err)
+	}
+	out.exit(code)
+}
+
+// usage reports invalid arguments
+func usage(message string) {
+	fail(exitUsage, errors.New(message))
+}
+
+// exitCode is the exit code of the failure class of err
+func exitCode(err error) int {
+	if _, locked := err.(*tractor.LockedError); locked || err == driver.ErrLocked {
+		return exitLocked
+	}
+	return exitFailure
+}
+
+// resultExitCode is the exit code of a failed result of a run
+func resultExitCode(r tractor.Result) int {
+	if r.Phase == tractor.PhaseMigrating && r.File != nil {
+		return exitMigration
+	}
+	return exitCode(r.Error)
+}
+
+func directionName(d direction.Direction) string {
+	switch d {
+	case direction.Up:
+		return "up"
+	case direction.Down:
+		return "down"
+	}
+	return ""
+}
+
+type errorRecord struct {
+	Type     string `json:"type"`
+	Class    string `json:"class"`
+	ExitCode int    `json:"exit_code"`
+	Message  string `json:"message"`
+
+	// the phase and file of a failed run
+	Phase   string `json:"phase,omitempty"`
+	File    string `json:"file,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+}
+
+func newErrorRecord(code int, err error) errorRecord {
+	return errorRecord{Type: "error", Class: exitClasses[code], ExitCode: code, Message: err.Error()}
+}
+
+type statementRecord struct {
+	RowsAffected int64 `json:"rows_affected"`
+	DurationNs   int64 `json:"duration_ns"`
+}
+
+type resultRecord struct {
+	Type       string            `json:"type"`
+	Phase      string            `json:"phase"`
+	File       string            `json:"file,omitempty"`
+	Source     string            `json:"source,omitempty"`
+	Version    uint64            `json:"version,omitempty"`
+	Direction  string            `json:"direction,omitempty"`
+	StartedAt  time.Time         `json:"started_at"`
+	DurationNs int64             `json:"duration_ns"`
+	Statements []statementRecord `json:"statements,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+func newResultRecord(r tractor.Result) resultRecord {
+	record := resultRecord{
+		Type:       "result",
+		Phase:      r.Phase.String(),
+		Version:    r.Version,
+		Direction:  directionName(r.Direction),
+		StartedAt:  r.StartedAt,
+		DurationNs: int64(r.Duration),
+	}
+	if r.File != nil {
+		record.File = r.File.FileName
+		record.Source = r.File.Source
+	}
+	for _, stmt := range r.Statements {
+		record.Statements = append(record.Statements, statementRecord{stmt.RowsAffected, int64(stmt.Duration)})
+	}
+	if r.Error != nil {
+		record.Error = r.Error.Error()
+	}
+	return record
+}
+
+type summaryRecord struct {
+	Type       string `json:"type"`
+	Migrated   int    `json:"migrated"`
+	DurationNs int64  `json:"duration_ns"`
+}
+
+type fileRecord struct {
+	Type       string `json:"type"`
+	File       string `json:"file"`
+	Version    uint64 `json:"version"`
+	Direction  string `json:"direction,omitempty"`
+	Checksum   string `json:"checksum,omitempty"`
+	OutOfOrder bool   `json:"out_of_order,omitempty"`
+	Source     string `json:"source,omitempty"`
+}
+
+func newFileRecord(recordType string, f *file.File) fileRecord {
+	return fileRecord{Type: recordType, File: f.FileName, Version: f.Version, Direction: directionName(f.Direction), Source: f.Source}
+}
+
+type versionRecord struct {
+	Type         string `json:"type"`
+	Version      uint64 `json:"version"`
+	Dirty        bool   `json:"dirty,omitempty"`
+	DirtyVersion uint64 `json:"dirty_version,omitempty"`
+}
+
+type statusRecord struct {
+	Type      string     `json:"type"`
+	Version   uint64     `json:"version"`
+	Name      string     `json:"name"`
+	Up        bool       `json:"up"`
+	Down      bool       `json:"down"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	Missing   bool       `json:"missing,omitempty"`
+}
+
+type historyRecord struct {
+	Type        string    `json:"type"`
+	Version     uint64    `json:"version"`
+	Name        string    `json:"name"`
+	AppliedAt   time.Time `json:"applied_at"`
+	DurationNs  int64     `json:"duration_ns"`
+	Checksum    string    `json:"checksum"`
+	AppliedBy   string    `json:"applied_by"`
+	ToolVersion string    `json:"tool_version"`
+}
+
+type driftRecord struct {
+	Type    string `json:"type"`
+	Kind    string `json:"kind"`
+	File    string `json:"file,omitempty"`
+	Version uint64 `json:"version"`
+}
+
+type lockRecord struct {
+	Type         string     `json:"type"`
+	Locked       bool       `json:"locked"`
+	Stale        bool       `json:"stale,omitempty"`
+	Native       bool       `json:"native,omitempty"`
+	Host         string     `json:"host,omitempty"`
+	Pid          int        `json:"pid,omitempty"`
+	ConnectionID int64      `json:"connection_id,omitempty"`
+	ToolVersion  string     `json:"tool_version,omitempty"`
+	AcquiredAt   *time.Time `json:"acquired_at,omitempty"`
+	HeartbeatAt  *time.Time `json:"heartbeat_at,omitempty"`
+}
+
+func newLockRecord(recordType string, holder *driver.LockInfo, ttl time.Duration) lockRecord {
+	record := lockRecord{Type: recordType}
+	if holder == nil {
+		return record
+	}
+
+	record.Locked = true
+	record.Stale = holder.Stale(ttl)
+	record.Native = holder.Native
+	record.Host = holder.Host
+	record.Pid = holder.Pid
+	record.ConnectionID = holder.ConnectionID
+	record.ToolVersion = holder.ToolVersion
+	if !holder.AcquiredAt.IsZero() {
+		record.AcquiredAt = &holder.AcquiredAt
+	}
+	if !holder.HeartbeatAt.IsZero() {
+		record.HeartbeatAt = &holder.HeartbeatAt
+	}
+	return record
+}
+
+type conflictRecord struct {
+	Type      string   `json:"type"`
+	Version   uint64   `json:"version"`
+	Reason    string   `json:"reason"`
+	FileNames []string `json:"files"`
+}
+
+type messageRecord struct {
+	Type    string `json:"type"`
+	Version uint64 `json:"version,omitempty"`
+	Message string `json:"message"`
+}
+
+type pathRecord struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+type planRecord struct {
+	Type           string `json:"type"`
+	CurrentVersion uint64 `json:"current_version"`
+}
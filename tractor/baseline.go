@@ -0,0 +1,58 @@
+package tractor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/netw00rk/sqltractor/driver"
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
+)
+
+// Baseline records the migrations up to and including version as applied
+// without running them, for databases whose schema was created by other means.
+// UpAsync continues with the migrations above version. Unless force is set,
+// Baseline refuses to touch a database that has applied migrations already.
+func (t *SqlTractor) Baseline(ctx context.Context, version uint64, force bool) ([]*file.File, error) {
+	d, err := t.driver()
+	if err != nil {
+		return nil, err
+	}
+
+	baseliner, ok := d.(driver.Baseliner)
+	if !ok {
+		return nil, errors.New("driver does not record baselines")
+	}
+
+	if version == 0 {
+		return nil, errors.New("baseline version must be greater than 0")
+	}
+
+	manager, err := t.manager()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := manager.GotoFrom(0, version)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	if !force {
+		current, err := d.VersionContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if current > 0 {
+			return nil, fmt.Errorf("database has applied migrations up to version %d, baseline has to be forced", current)
+		}
+	}
+
+	return files, baseliner.Baseline(ctx, files)
+}
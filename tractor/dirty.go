@@ -0,0 +1,83 @@
+package tractor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/netw00rk/sqltractor/driver"
+)
+
+// DirtyError is returned when a run would start from a version
+// left dirty by a migration that failed halfway.
+type DirtyError struct {
+	Version uint64
+}
+
+func (e *DirtyError) Error() string {
+	return fmt.Sprintf("database is dirty at version %d, repair it and force a clean version", e.Version)
+}
+
+// Returns the version left dirty by a failed migration, dirty is false if
+// the database is clean. The driver has to implement driver.DirtyTracker.
+func (t *SqlTractor) Dirty(ctx context.Context) (uint64, bool, error) {
+	tracker, err := t.dirtyTracker()
+	if err != nil {
+		return 0, false, err
+	}
+
+	return tracker.Dirty(ctx)
+}
+
+// Force makes version the current clean version without migrating,
+// after an operator has repaired a dirty database by hand.
+func (t *SqlTractor) Force(ctx context.Context, version uint64) error {
+	tracker, err := t.dirtyTracker()
+	if err != nil {
+		return err
+	}
+
+	if err := t.lock(ctx); err != nil {
+		return err
+	}
+	defer t.release()
+
+	return tracker.Force(ctx, version)
+}
+
+// checkDirty fails if the driver tracks dirty versions and one is dirty
+func (t *SqlTractor) checkDirty(ctx context.Context) error {
+	d, err := t.driver()
+	if err != nil {
+		return err
+	}
+
+	tracker, ok := d.(driver.DirtyTracker)
+	if !ok {
+		return nil
+	}
+
+	version, dirty, err := tracker.Dirty(ctx)
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		return &DirtyError{version}
+	}
+	return nil
+}
+
+func (t *SqlTractor) dirtyTracker() (driver.DirtyTracker, error) {
+	d, err := t.driver()
+	if err != nil {
+		return nil, err
+	}
+
+	tracker, ok := d.(driver.DirtyTracker)
+	if !ok {
+		return nil, errors.New("driver does not track dirty versions")
+	}
+
+	return tracker, nil
+}
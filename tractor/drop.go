@@ -0,0 +1,29 @@
+package tractor
+
+import (
+	"context"
+	"errors"
+
+	"github.com/netw00rk/sqltractor/driver"
+)
+
+// Drop wipes the database schema regardless of the applied migrations
+// and leaves the database at version 0. The driver has to implement driver.Dropper.
+func (t *SqlTractor) Drop(ctx context.Context) error {
+	d, err := t.driver()
+	if err != nil {
+		return err
+	}
+
+	dropper, ok := d.(driver.Dropper)
+	if !ok {
+		return errors.New("driver does not drop schemas")
+	}
+
+	if err := t.lock(ctx); err != nil {
+		return err
+	}
+	defer t.release()
+
+	return dropper.Drop(ctx)
+}
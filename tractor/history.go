@@ -0,0 +1,24 @@
+package tractor
+
+import (
+	"context"
+	"errors"
+
+	"github.com/netw00rk/sqltractor/driver"
+)
+
+// Returns the recorded details of all applied migrations ordered by version.
+// The driver has to implement driver.Historian.
+func (t *SqlTractor) History(ctx context.Context) ([]*driver.Record, error) {
+	d, err := t.driver()
+	if err != nil {
+		return nil, err
+	}
+
+	historian, ok := d.(driver.Historian)
+	if !ok {
+		return nil, errors.New("driver does not record migration history")
+	}
+
+	return historian.History(ctx)
+}
@@ -0,0 +1,171 @@
+package tractor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/netw00rk/sqltractor/driver"
+)
+
+// lockRetryInterval is the pause between attempts to acquire a held lock
+var lockRetryInterval = 500 * time.Millisecond
+
+// LockedError is returned when the lock is still held by
+// another process after waiting for LockTimeout.
+type LockedError struct {
+	// Holder of the lock, nil if the driver does not record it
+	Holder *driver.LockInfo
+}
+
+func (e *LockedError) Error() string {
+	if e.Holder == nil {
+		return driver.ErrLocked.Error()
+	}
+	return fmt.Sprintf("%s: %s", driver.ErrLocked, e.Holder)
+}
+
+// Returns the holder of the migration lock, nil if the lock is free.
+// The driver has to implement driver.LockInspector.
+func (t *SqlTractor) LockStatus(ctx context.Context) (*driver.LockInfo, error) {
+	inspector, err := t.lockInspector()
+	if err != nil {
+		return nil, err
+	}
+
+	return inspector.LockInfo(ctx)
+}
+
+// Removes the migration lock left by another process and returns its holder,
+// nil if the lock was free. Unless force is set, only a stale lock is removed, see LockTTL.
+func (t *SqlTractor) Unlock(ctx context.Context, force bool) (*driver.LockInfo, error) {
+	inspector, err := t.lockInspector()
+	if err != nil {
+		return nil, err
+	}
+
+	holder, err := inspector.LockInfo(ctx)
+	if err != nil || holder == nil {
+		return nil, err
+	}
+
+	if !force && !holder.Stale(t.LockTTL) {
+		return nil, fmt.Errorf("lock held by %s is not stale", holder)
+	}
+
+	return holder, inspector.Unlock(ctx, holder.Token)
+}
+
+// lock retries for up to LockTimeout while another process holds the lock
+// and takes over a stale lock
+func (t *SqlTractor) lock(ctx context.Context) error {
+	d, err := t.driver()
+	if err != nil {
+		return err
+	}
+
+	inspector, _ := d.(driver.LockInspector)
+	deadline := time.Now().Add(t.LockTimeout)
+	for {
+		err := d.LockContext(ctx)
+		if err != driver.ErrLocked {
+			return err
+		}
+
+		var holder *driver.LockInfo
+		if inspector != nil {
+			if holder, err = inspector.LockInfo(ctx); err != nil {
+				return err
+			}
+
+			if holder != nil && holder.Stale(t.LockTTL) {
+				if err := inspector.Unlock(ctx, holder.Token); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if !time.Now().Before(deadline) {
+			return &LockedError{Holder: holder}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// heartbeat keeps the held lock from going stale until the returned func is
+// called, which returns the error the heartbeat gave up on. It gives up and
+// calls cancel when another process took the lock over, or when no heartbeat
+// succeeded for LockTTL, after which the lock may be taken over.
+func (t *SqlTractor) heartbeat(cancel context.CancelFunc) func() error {
+	inspector, err := t.lockInspector()
+	if err != nil || t.LockTTL <= 0 {
+		return func() error { return nil }
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	var lost error
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(t.LockTTL / 3)
+		defer ticker.Stop()
+		beat := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := inspector.Heartbeat(context.Background())
+				if err == nil {
+					beat = time.Now()
+					continue
+				}
+
+				if err == driver.ErrLockLost || time.Since(beat) >= t.LockTTL {
+					lost = fmt.Errorf("lock heartbeat failed, stopped the run: %v", err)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	return func() error {
+		close(done)
+		<-stopped
+		return lost
+	}
+}
+
+// release is deliberately not bound to the run's context, so that
+// the lock is dropped even when the run has been cancelled.
+func (t *SqlTractor) release() error {
+	driver, err := t.driver()
+	if err != nil {
+		return err
+	}
+
+	return driver.Release()
+}
+
+func (t *SqlTractor) lockInspector() (driver.LockInspector, error) {
+	d, err := t.driver()
+	if err != nil {
+		return nil, err
+	}
+
+	inspector, ok := d.(driver.LockInspector)
+	if !ok {
+		return nil, errors.New("driver does not record the lock holder")
+	}
+
+	return inspector, nil
+}
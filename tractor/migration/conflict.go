@@ -0,0 +1,74 @@
+package migration
+
+import (
+	"log"
+	"strings"
+
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
+)
+
+// Options configures NewManagerWithOptions
+type Options struct {
+	// FailOnConflict makes the manager fail with a ConflictError
+	// instead of warning about conflicting files
+	FailOnConflict bool
+
+	// Warn is called for every conflict unless FailOnConflict is set.
+	// Conflicts are logged with the standard logger if Warn is nil.
+	Warn func(conflict *Conflict)
+}
+
+func (o Options) warn(conflict *Conflict) {
+	if o.Warn != nil {
+		o.Warn(conflict)
+		return
+	}
+	log.Printf("sqltractor: %s", conflict)
+}
+
+// Conflict describes migration files of one version that contradict each other
+type Conflict struct {
+	Version uint64
+
+	// what is wrong with the files
+	Reason string
+
+	// names of the files involved
+	FileNames []string
+}
+
+func newConflict(version uint64, reason string, files ...*file.File) *Conflict {
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.FileName
+	}
+
+	return &Conflict{
+		Version:   version,
+		Reason:    reason,
+		FileNames: names,
+	}
+}
+
+func (c *Conflict) String() string {
+	return c.Reason + ": " + strings.Join(c.FileNames, ", ")
+}
+
+// ConflictError lists every conflict found by a manager
+// created with FailOnConflict.
+type ConflictError []*Conflict
+
+func (e ConflictError) Error() string {
+	parts := make([]string, len(e))
+	for i, conflict := range e {
+		parts[i] = conflict.String()
+	}
+	return "conflicting migration files: " + strings.Join(parts, "; ")
+}
+
+// byFileName sorts files by name, so that conflicts resolve the same way every time
+type byFileName []*file.File
+
+func (f byFileName) Len() int           { return len(f) }
+func (f byFileName) Less(i, j int) bool { return f[i].FileName < f[j].FileName }
+func (f byFileName) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
@@ -3,6 +3,9 @@ package file
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"go/token"
@@ -15,8 +18,17 @@ import (
 
 var filenameRegex = regexp.MustCompile(`^([0-9]+)_(.*)\.(up|down)\..*$`)
 
+// squashHeader starts the first line of a squashed up migration,
+// followed by the versions it replaces
+const squashHeader = "-- sqltractor:squashed"
+
 type ContentFunc func() ([]byte, error)
 
+// MigrationFunc is a migration written in Go. conn is the driver's live
+// connection or transaction: *sql.Tx for postgres, mysql and sqlite3,
+// *gocql.Session for cassandra.
+type MigrationFunc func(ctx context.Context, conn interface{}) error
+
 // File represents one file on disk.
 // Example: 001_initial_plan_to_do_sth.up.sql
 type File struct {
@@ -35,6 +47,12 @@ type File struct {
 	// UP or DOWN migration
 	Direction direction.Direction
 
+	// Go migration the driver runs instead of the content, if set
+	Func MigrationFunc
+
+	// name of the source the file was read from, set by reader.MultiReader
+	Source string
+
 	content []byte
 }
 
@@ -55,6 +73,24 @@ func NewFile(fileName string, contentFunc ContentFunc) (*File, error) {
 
 }
 
+// NewFuncFile creates a Go migration, its FileName is built like
+// the one of a migration file, e.g. 001_backfill_users.up.go
+func NewFuncFile(version uint64, name string, d direction.Direction, fn MigrationFunc) *File {
+	suffix := "up"
+	if d == direction.Down {
+		suffix = "down"
+	}
+
+	return &File{
+		FileName:    fmt.Sprintf("%03d_%s.%s.go", version, name, suffix),
+		Version:     version,
+		Name:        name,
+		ContentFunc: func() ([]byte, error) { return nil, nil },
+		Direction:   d,
+		Func:        fn,
+	}
+}
+
 // ReadContent reads the file's content if the content is empty
 func (f *File) Content() ([]byte, error) {
 	if len(f.content) == 0 {
@@ -67,6 +103,56 @@ func (f *File) Content() ([]byte, error) {
 	return f.content, nil
 }
 
+// Checksum returns the hex encoded SHA-256 of the file's content.
+// Go migrations have no content, theirs is the one of FileName, so that
+// a renamed or replaced Go migration is told apart, but changes to its
+// body are not.
+func (f *File) Checksum() (string, error) {
+	content := []byte(f.FileName)
+	if f.Func == nil {
+		var err error
+		if content, err = f.Content(); err != nil {
+			return "", err
+		}
+	}
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// SquashedVersions returns the versions a squashed migration replaces,
+// including its own, or nil if the file is no squashed migration.
+func (f *File) SquashedVersions() ([]uint64, error) {
+	content, err := f.Content()
+	if err != nil {
+		return nil, err
+	}
+
+	line := string(bytes.SplitN(content, []byte("\n"), 2)[0])
+	if !strings.HasPrefix(line, squashHeader) {
+		return nil, nil
+	}
+
+	versions := make([]uint64, 0)
+	for _, field := range strings.Fields(strings.TrimPrefix(line, squashHeader)) {
+		version, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse squashed version '%v' in %s", field, f.FileName)
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// SquashHeader returns the first line of a migration squashing versions.
+func SquashHeader(versions []uint64) string {
+	fields := make([]string, len(versions))
+	for i, version := range versions {
+		fields[i] = strconv.FormatUint(version, 10)
+	}
+	return squashHeader + " " + strings.Join(fields, " ")
+}
+
 // parseFilenameSchema parses the filename
 func parseFilenameSchema(filename string) (version uint64, name string, d direction.Direction, err error) {
 	matches := filenameRegex.FindStringSubmatch(filename)
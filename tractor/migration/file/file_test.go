@@ -1,6 +1,7 @@
 package file
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
@@ -44,6 +45,52 @@ func (s *ParserTestSuite) TestReadContent() {
 	s.Equal([]byte("test"), content)
 }
 
+func (s *ParserTestSuite) TestChecksum() {
+	file := new(File)
+	file.ContentFunc = MockedContentFunc
+	checksum, err := file.Checksum()
+	s.Nil(err)
+	s.Equal("9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", checksum)
+}
+
+func (s *ParserTestSuite) TestNewFuncFile() {
+	file := NewFuncFile(7, "backfill", direction.Down, func(ctx context.Context, conn interface{}) error {
+		return nil
+	})
+	s.Equal("007_backfill.down.go", file.FileName)
+	s.Equal(uint64(7), file.Version)
+	s.Equal("backfill", file.Name)
+	s.Equal(direction.Direction(direction.Down), file.Direction)
+	s.NotNil(file.Func)
+
+	content, err := file.Content()
+	s.Nil(err)
+	s.Equal(0, len(content))
+
+	checksum, err := file.Checksum()
+	s.Nil(err)
+	s.Equal("a94aea923f0f45c15e86e1e4c0587ab708aef275b01cdb1d7835b82422836620", checksum)
+
+	renamed, err := NewFuncFile(7, "backfill_users", direction.Down, file.Func).Checksum()
+	s.Nil(err)
+	s.NotEqual(checksum, renamed)
+}
+
+func (s *ParserTestSuite) TestSquashedVersions() {
+	file, _ := NewFile("003_squashed.up.sql", func() ([]byte, error) {
+		return []byte(SquashHeader([]uint64{1, 2, 3}) + "\nCREATE TABLE t (id INT);"), nil
+	})
+	versions, err := file.SquashedVersions()
+	s.Nil(err)
+	s.Equal([]uint64{1, 2, 3}, versions)
+
+	file.ContentFunc = MockedContentFunc
+	file.content = nil
+	versions, err = file.SquashedVersions()
+	s.Nil(err)
+	s.Nil(versions)
+}
+
 func (s *ParserTestSuite) TestInvalidNames() {
 	tests := []string{
 		"-1_test_file.down.sql", "test_file.down.sql", "100_test_file.down",
@@ -2,6 +2,7 @@ package migration
 
 import (
 	"errors"
+	"fmt"
 	"sort"
 
 	"github.com/netw00rk/sqltractor/reader"
@@ -12,14 +13,25 @@ import (
 // Manager is a slice of Migration
 type Manager []*Migration
 
-// Initialize slice of Migration structures reads all migration files from a given path
+// Initialize slice of Migration structures reads all migration files from a given path.
+// Conflicting files are reported as warnings, see NewManagerWithOptions.
 func NewManager(reader reader.Reader) (Manager, error) {
+	return NewManagerWithOptions(reader, Options{})
+}
+
+// NewManagerWithOptions reads all migration files like NewManager and reports
+// conflicting files as configured in options. Of files sharing a version and
+// direction, the last one in file name order is used.
+func NewManagerWithOptions(reader reader.Reader, options Options) (Manager, error) {
 	files, err := reader.Read()
 	if err != nil {
 		return nil, err
 	}
 
+	sort.Sort(byFileName(files))
+
 	tmp := make(map[uint64]*Migration)
+	conflicts := make(ConflictError, 0)
 	for _, file := range files {
 		var migration *Migration
 		var ok bool
@@ -32,8 +44,14 @@ func NewManager(reader reader.Reader) (Manager, error) {
 
 		switch file.Direction {
 		case direction.Up:
+			if migration.UpFile != nil {
+				conflicts = append(conflicts, newConflict(file.Version, "duplicate up files", migration.UpFile, file))
+			}
 			migration.UpFile = file
 		case direction.Down:
+			if migration.DownFile != nil {
+				conflicts = append(conflicts, newConflict(file.Version, "duplicate down files", migration.DownFile, file))
+			}
 			migration.DownFile = file
 		default:
 			return nil, errors.New("Unsupported direction.Direction Type")
@@ -48,6 +66,24 @@ func NewManager(reader reader.Reader) (Manager, error) {
 	}
 
 	sort.Sort(newFiles)
+
+	for _, migration := range newFiles {
+		if migration.UpFile == nil {
+			conflicts = append(conflicts, newConflict(migration.Version, "down file without up file", migration.DownFile))
+		} else if migration.DownFile != nil && migration.UpFile.Name != migration.DownFile.Name {
+			conflicts = append(conflicts, newConflict(migration.Version, "up and down names differ", migration.UpFile, migration.DownFile))
+		}
+	}
+
+	if len(conflicts) > 0 {
+		if options.FailOnConflict {
+			return nil, conflicts
+		}
+		for _, conflict := range conflicts {
+			options.warn(conflict)
+		}
+	}
+
 	return newFiles, nil
 }
 
@@ -77,6 +113,24 @@ func (mm Manager) ToLastFrom(version uint64) []*file.File {
 	return files
 }
 
+// Pending fetches all (up) migration files whose version is not in applied,
+// including versions lower than the most recent applied one.
+func (mm Manager) Pending(applied []uint64) []*file.File {
+	done := make(map[uint64]bool, len(applied))
+	for _, version := range applied {
+		done[version] = true
+	}
+
+	sort.Sort(mm)
+	files := make([]*file.File, 0)
+	for _, migration := range mm {
+		if !done[migration.Version] && migration.UpFile != nil {
+			files = append(files, migration.UpFile)
+		}
+	}
+	return files
+}
+
 // From travels relatively through migration files.
 //
 // 		+1 will fetch the next up migration file
@@ -124,6 +178,82 @@ func (mm Manager) From(version uint64, relativeN int) []*file.File {
 	return files
 }
 
+// GotoFrom fetches the exact migration files needed to travel from version
+// to target. Target must be a known migration version or 0, which rolls back
+// all migrations. Gaps between version numbers are respected.
+//
+//	target > version will fetch up files of (version, target]
+//	target < version will fetch down files of (target, version]
+//	target == version will fetch nothing
+func (mm Manager) GotoFrom(version, target uint64) ([]*file.File, error) {
+	files := make([]*file.File, 0)
+
+	if target != 0 && !mm.has(target) {
+		return nil, fmt.Errorf("unknown migration version %d", target)
+	}
+
+	if target > version {
+		sort.Sort(mm)
+		for _, migration := range mm {
+			if migration.Version > version && migration.Version <= target {
+				if migration.UpFile == nil {
+					return nil, fmt.Errorf("missing up file for version %d", migration.Version)
+				}
+				files = append(files, migration.UpFile)
+			}
+		}
+	} else if target < version {
+		sort.Sort(sort.Reverse(mm))
+		for _, migration := range mm {
+			if migration.Version <= version && migration.Version > target {
+				if migration.DownFile == nil {
+					return nil, fmt.Errorf("missing down file for version %d", migration.Version)
+				}
+				files = append(files, migration.DownFile)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// Redo fetches the down files of the last n migrations up to and including
+// version, newest first, followed by their up files, oldest first.
+// Every one of them needs both files.
+func (mm Manager) Redo(version uint64, n int) ([]*file.File, error) {
+	downs := make([]*file.File, 0)
+	ups := make([]*file.File, 0)
+	if n <= 0 {
+		return downs, nil
+	}
+
+	sort.Sort(sort.Reverse(mm))
+	for _, migration := range mm {
+		if len(downs) == n {
+			break
+		}
+		if migration.Version > version {
+			continue
+		}
+		if migration.UpFile == nil || migration.DownFile == nil {
+			return nil, fmt.Errorf("missing up or down file for version %d", migration.Version)
+		}
+		downs = append(downs, migration.DownFile)
+		ups = append([]*file.File{migration.UpFile}, ups...)
+	}
+
+	return append(downs, ups...), nil
+}
+
+func (mm Manager) has(version uint64) bool {
+	for _, migration := range mm {
+		if migration.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
 // Len is the number of elements in the collection.
 // Required by Sort Interface{}
 func (mm Manager) Len() int {
@@ -3,6 +3,7 @@ package migration
 import (
 	"fmt"
 	"os"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
@@ -39,6 +40,9 @@ func (s *ManagerTestSuite) TearDownSuite() {
 }
 
 func (s *ManagerTestSuite) TestReadMigrations() {
+	// expectations are listed from the newest migration, manager methods sort in place
+	sort.Sort(sort.Reverse(s.manager))
+
 	var tests = []struct {
 		up   bool
 		down bool
@@ -75,6 +79,28 @@ func (s *ManagerTestSuite) TestToLastFrom() {
 	}
 }
 
+func (s *ManagerTestSuite) TestPending() {
+	var tests = []struct {
+		applied          []uint64
+		expectedVersions []uint64
+	}{
+		{nil, []uint64{1, 2, 101, 301}},
+		{[]uint64{1, 101}, []uint64{2, 301}},
+		{[]uint64{1, 2, 101, 301}, nil},
+		{[]uint64{301}, []uint64{1, 2, 101}},
+	}
+
+	for _, test := range tests {
+		files := s.manager.Pending(test.applied)
+		s.Equal(len(test.expectedVersions), len(files))
+
+		for i, version := range test.expectedVersions {
+			s.Equal(version, files[i].Version, "migration version should be equal")
+			s.Equal(direction.Up, files[i].Direction, "direction of migration should be up")
+		}
+	}
+}
+
 func (s *ManagerTestSuite) TestFrom() {
 	var tests = []struct {
 		from              uint64
@@ -103,6 +129,162 @@ func (s *ManagerTestSuite) TestFrom() {
 
 }
 
+func (s *ManagerTestSuite) TestGotoFrom() {
+	var tests = []struct {
+		from              uint64
+		target            uint64
+		expectedVersions  []uint64
+		expectedDirection direction.Direction
+	}{
+		{0, 101, []uint64{1, 2, 101}, direction.Up},
+		{1, 301, []uint64{2, 101, 301}, direction.Up},
+		{101, 1, []uint64{101, 2}, direction.Down},
+		{2, 0, []uint64{2, 1}, direction.Down},
+		{101, 101, nil, 0},
+	}
+
+	for _, test := range tests {
+		files, err := s.manager.GotoFrom(test.from, test.target)
+		s.Nil(err)
+		s.Equal(len(test.expectedVersions), len(files))
+
+		for i, version := range test.expectedVersions {
+			s.Equal(version, files[i].Version, "migration version should be equal")
+			s.Equal(test.expectedDirection, files[i].Direction, "direction of migration should be %s", test.expectedDirection)
+		}
+	}
+}
+
+func (s *ManagerTestSuite) TestGotoFromErrors() {
+	var tests = []struct {
+		from   uint64
+		target uint64
+	}{
+		{0, 5},     // unknown version
+		{0, 401},   // 401 has no up file
+		{401, 101}, // 301 has no down file
+	}
+
+	for _, test := range tests {
+		_, err := s.manager.GotoFrom(test.from, test.target)
+		s.NotNil(err, "goto from %d to %d should fail", test.from, test.target)
+	}
+}
+
+func (s *ManagerTestSuite) TestRedo() {
+	var tests = []struct {
+		from               uint64
+		n                  int
+		expectedVersions   []uint64
+		expectedDirections []direction.Direction
+	}{
+		{2, 1, []uint64{2, 2}, []direction.Direction{direction.Down, direction.Up}},
+		{101, 2, []uint64{101, 2, 2, 101}, []direction.Direction{direction.Down, direction.Down, direction.Up, direction.Up}},
+		{2, 5, []uint64{2, 1, 1, 2}, []direction.Direction{direction.Down, direction.Down, direction.Up, direction.Up}},
+		{0, 1, nil, nil},
+		{2, 0, nil, nil},
+	}
+
+	for _, test := range tests {
+		files, err := s.manager.Redo(test.from, test.n)
+		s.Nil(err)
+		s.Equal(len(test.expectedVersions), len(files))
+
+		for i, version := range test.expectedVersions {
+			s.Equal(version, files[i].Version, "migration version should be equal")
+			s.Equal(test.expectedDirections[i], files[i].Direction)
+		}
+	}
+
+	_, err := s.manager.Redo(301, 1)
+	s.NotNil(err, "301 has no down file")
+}
+
+func (s *ManagerTestSuite) TestSquash() {
+	manager, err := NewManager(memory.NewMemoryReader(map[string][]byte{
+		"001_users.up.sql":      []byte("CREATE TABLE users (id INT)"),
+		"001_users.down.sql":    []byte("DROP TABLE users;"),
+		"002_empty.up.sql":      nil,
+		"002_empty.down.sql":    nil,
+		"003_orders.up.sql":     []byte("CREATE TABLE orders (id INT);"),
+		"003_orders.down.sql":   []byte("DROP TABLE orders"),
+		"004_comments.up.sql":   []byte("CREATE TABLE comments (id INT);"),
+		"004_comments.down.sql": []byte("DROP TABLE comments;"),
+	}))
+	s.Nil(err)
+
+	up, down, replaced, err := manager.Squash(3)
+	s.Nil(err)
+	s.Equal(6, len(replaced))
+	s.Equal("003_orders.up.sql", up.FileName)
+	s.Equal("003_orders.down.sql", down.FileName)
+
+	versions, err := up.SquashedVersions()
+	s.Nil(err)
+	s.Equal([]uint64{1, 2, 3}, versions)
+
+	content, _ := up.Content()
+	s.Equal("-- sqltractor:squashed 1 2 3\n"+
+		"\n-- 001_users.up.sql\nCREATE TABLE users (id INT);\n"+
+		"\n-- 003_orders.up.sql\nCREATE TABLE orders (id INT);\n", string(content))
+
+	content, _ = down.Content()
+	s.Equal("\n-- 003_orders.down.sql\nDROP TABLE orders;\n"+
+		"\n-- 001_users.down.sql\nDROP TABLE users;\n", string(content))
+
+	// squashing again keeps the versions of the earlier squash
+	resquashed, err := NewManager(memory.NewMemoryReader(map[string][]byte{
+		"003_orders.up.sql":     []byte("-- sqltractor:squashed 1 2 3\nCREATE TABLE orders (id INT);"),
+		"003_orders.down.sql":   []byte("DROP TABLE orders;"),
+		"004_comments.up.sql":   []byte("CREATE TABLE comments (id INT);"),
+		"004_comments.down.sql": []byte("DROP TABLE comments;"),
+	}))
+	s.Nil(err)
+
+	up, _, _, err = resquashed.Squash(4)
+	s.Nil(err)
+	versions, err = up.SquashedVersions()
+	s.Nil(err)
+	s.Equal([]uint64{1, 2, 3, 4}, versions)
+
+	_, _, _, err = manager.Squash(5)
+	s.NotNil(err)
+}
+
+func (s *ManagerTestSuite) TestConflicts() {
+	reader := memory.NewMemoryReader(map[string][]byte{
+		"001_init.up.sql":            nil,
+		"001_init.down.sql":          nil,
+		"002_add_users.up.sql":       nil,
+		"002_add_orders.up.sql":      nil,
+		"002_add_orders.down.sql":    nil,
+		"101_create_table.up.sql":    nil,
+		"101_drop_tables.down.sql":   nil,
+		"401_migrationfile.down.sql": nil,
+	})
+
+	_, err := NewManagerWithOptions(reader, Options{FailOnConflict: true})
+	conflicts, ok := err.(ConflictError)
+	s.True(ok)
+	s.Equal(4, len(conflicts))
+	s.Equal(uint64(2), conflicts[0].Version)
+	s.Equal([]string{"002_add_orders.up.sql", "002_add_users.up.sql"}, conflicts[0].FileNames)
+	// the remaining up file of version 2 does not match its down file either
+	s.Equal([]string{"002_add_users.up.sql", "002_add_orders.down.sql"}, conflicts[1].FileNames)
+	s.Equal([]string{"101_create_table.up.sql", "101_drop_tables.down.sql"}, conflicts[2].FileNames)
+	s.Equal([]string{"401_migrationfile.down.sql"}, conflicts[3].FileNames)
+
+	warnings := make([]*Conflict, 0)
+	manager, err := NewManagerWithOptions(reader, Options{Warn: func(c *Conflict) {
+		warnings = append(warnings, c)
+	}})
+	s.Nil(err)
+	s.Equal(4, len(warnings))
+
+	// the last file in name order wins
+	s.Equal("002_add_users.up.sql", manager.ToLastFrom(1)[0].FileName)
+}
+
 func TestManagerSuite(t *testing.T) {
 	suite.Run(t, new(ManagerTestSuite))
 }
@@ -0,0 +1,122 @@
+package migration
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
+)
+
+// Squash consolidates the migrations up to and including version into one
+// up and one down file, named like the files of version. The up content
+// concatenates the up files in ascending order below a header listing the
+// squashed versions, see file.File.SquashedVersions. The down content
+// concatenates the down files in descending order. replaced lists all
+// squashed files, including the files of version.
+func (mm Manager) Squash(version uint64) (up, down *file.File, replaced []*file.File, err error) {
+	if !mm.has(version) {
+		return nil, nil, nil, fmt.Errorf("unknown migration version %d", version)
+	}
+
+	sort.Sort(mm)
+	versions := make([]uint64, 0)
+	ups := make([][]byte, 0)
+	downs := make([][]byte, 0)
+	replaced = make([]*file.File, 0)
+
+	var last *Migration
+	for _, migration := range mm {
+		if migration.Version > version {
+			break
+		}
+		last = migration
+
+		for _, f := range []*file.File{migration.UpFile, migration.DownFile} {
+			if f == nil {
+				return nil, nil, nil, fmt.Errorf("missing up or down file for version %d", migration.Version)
+			}
+			if f.Func != nil {
+				return nil, nil, nil, fmt.Errorf("can not squash Go migration %s", f.FileName)
+			}
+		}
+
+		squashed, err := migration.UpFile.SquashedVersions()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if squashed == nil {
+			squashed = []uint64{migration.Version}
+		}
+		versions = append(versions, squashed...)
+
+		content, err := squashContent(migration.UpFile)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		ups = append(ups, content)
+
+		content, err = squashContent(migration.DownFile)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		downs = append([][]byte{content}, downs...)
+
+		replaced = append(replaced, migration.UpFile, migration.DownFile)
+	}
+
+	upContent := append([]byte(file.SquashHeader(versions)+"\n"), bytes.Join(ups, nil)...)
+	downContent := bytes.Join(downs, nil)
+
+	up = &file.File{
+		FileName:    last.UpFile.FileName,
+		Version:     version,
+		Name:        last.UpFile.Name,
+		ContentFunc: func() ([]byte, error) { return upContent, nil },
+		Direction:   last.UpFile.Direction,
+	}
+
+	down = &file.File{
+		FileName:    last.DownFile.FileName,
+		Version:     version,
+		Name:        last.DownFile.Name,
+		ContentFunc: func() ([]byte, error) { return downContent, nil },
+		Direction:   last.DownFile.Direction,
+	}
+
+	return up, down, replaced, nil
+}
+
+// squashContent is the content of f below a comment naming it, without the
+// squash header of an earlier squash and terminated by a semicolon, so that
+// drivers splitting statements do not merge it with the next file.
+// Empty files are left out.
+func squashContent(f *file.File) ([]byte, error) {
+	content, err := f.Content()
+	if err != nil {
+		return nil, err
+	}
+
+	if squashed, err := f.SquashedVersions(); err != nil {
+		return nil, err
+	} else if squashed != nil {
+		lines := bytes.SplitN(content, []byte("\n"), 2)
+		content = nil
+		if len(lines) == 2 {
+			content = lines[1]
+		}
+	}
+
+	// a comment on its own would make an empty statement
+	content = bytes.TrimSpace(content)
+	if len(content) == 0 {
+		return nil, nil
+	}
+
+	terminator := ""
+	if !bytes.HasSuffix(content, []byte(";")) {
+		terminator = ";"
+	}
+
+	return []byte(fmt.Sprintf("\n-- %s\n%s%s\n", f.FileName, content, terminator)), nil
+}
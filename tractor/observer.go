@@ -0,0 +1,44 @@
+package tractor
+
+import (
+	"context"
+	"time"
+
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
+)
+
+// Observer is notified by SqlTractor around every run and migrated file.
+// Callbacks run in the goroutine applying the migrations, while the lock is
+// held, except for AfterRun of a run that could not take the lock.
+// An error returned by BeforeRun, BeforeMigration or AfterMigration stops
+// the run and is delivered as its Result.
+type Observer interface {
+	// BeforeRun is called with all files of a run before the first one is applied.
+	BeforeRun(ctx context.Context, files []*file.File) error
+
+	// BeforeMigration is called before a file is applied.
+	BeforeMigration(ctx context.Context, f *file.File) error
+
+	// AfterMigration is called after a file has been applied,
+	// err is the migration error if it failed.
+	AfterMigration(ctx context.Context, f *file.File, duration time.Duration, err error) error
+
+	// AfterRun is called when the run is over, err is the error that stopped it.
+	// It is called for every run, also if it stopped before BeforeRun, like
+	// when taking the lock or selecting the files failed.
+	AfterRun(ctx context.Context, err error)
+}
+
+// NopObserver implements Observer with empty callbacks, embed it
+// to implement only the callbacks of interest.
+type NopObserver struct{}
+
+func (NopObserver) BeforeRun(ctx context.Context, files []*file.File) error { return nil }
+
+func (NopObserver) BeforeMigration(ctx context.Context, f *file.File) error { return nil }
+
+func (NopObserver) AfterMigration(ctx context.Context, f *file.File, duration time.Duration, err error) error {
+	return nil
+}
+
+func (NopObserver) AfterRun(ctx context.Context, err error) {}
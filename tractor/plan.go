@@ -0,0 +1,93 @@
+package tractor
+
+import (
+	"context"
+
+	"github.com/netw00rk/sqltractor/tractor/migration/direction"
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
+)
+
+// Step is a single migration a run would apply
+type Step struct {
+	// version of the migration
+	Version uint64
+
+	// UP or DOWN migration
+	Direction direction.Direction
+
+	// name of the migration file
+	FileName string
+
+	// hex encoded SHA-256 of the file content
+	Checksum string
+
+	// up migration below the current version, applied in out-of-order mode
+	OutOfOrder bool
+
+	// file that would be applied
+	File *file.File
+}
+
+// Plan describes what a run would do without executing it.
+type Plan struct {
+	// version the run starts from
+	Version uint64
+
+	// steps in the order they would be applied
+	Steps []*Step
+}
+
+// Returns the plan of UpAsync, out-of-order steps are flagged
+func (t *SqlTractor) PlanUp(ctx context.Context) (*Plan, error) {
+	return t.plan(ctx, t.selectUp(ctx))
+}
+
+// Returns the plan of DownAsync
+func (t *SqlTractor) PlanDown(ctx context.Context) (*Plan, error) {
+	return t.plan(ctx, selectDown)
+}
+
+// Returns the plan of MigrateAsync
+func (t *SqlTractor) PlanMigrate(ctx context.Context, relativeN int) (*Plan, error) {
+	return t.plan(ctx, selectRelative(relativeN))
+}
+
+// Returns the plan of GotoAsync
+func (t *SqlTractor) PlanGoto(ctx context.Context, target uint64) (*Plan, error) {
+	return t.plan(ctx, selectGoto(target))
+}
+
+// Returns the plan of RedoAsync
+func (t *SqlTractor) PlanRedo(ctx context.Context, n int) (*Plan, error) {
+	return t.plan(ctx, selectRedo(n))
+}
+
+func (t *SqlTractor) plan(ctx context.Context, sel selector) (*Plan, error) {
+	version, files, err := t.selectFiles(ctx, sel)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		Version: version,
+		Steps:   make([]*Step, 0, len(files)),
+	}
+
+	for _, f := range files {
+		checksum, err := f.Checksum()
+		if err != nil {
+			return nil, err
+		}
+
+		plan.Steps = append(plan.Steps, &Step{
+			Version:    f.Version,
+			Direction:  f.Direction,
+			FileName:   f.FileName,
+			Checksum:   checksum,
+			OutOfOrder: f.Direction == direction.Up && f.Version < version,
+			File:       f,
+		})
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,87 @@
+package tractor
+
+import (
+	"time"
+
+	"github.com/netw00rk/sqltractor/driver"
+	"github.com/netw00rk/sqltractor/tractor/migration/direction"
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
+)
+
+// Phase of a run a Result was sent from
+type Phase int
+
+const (
+	// checking the database and resolving versions and files, once the lock is held
+	PhasePreparing Phase = iota
+
+	// acquiring the driver's lock
+	PhaseLocking
+
+	// applying a migration file
+	PhaseMigrating
+
+	// releasing the driver's lock
+	PhaseReleasing
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhasePreparing:
+		return "preparing"
+	case PhaseLocking:
+		return "locking"
+	case PhaseMigrating:
+		return "migrating"
+	case PhaseReleasing:
+		return "releasing"
+	}
+	return "unknown"
+}
+
+// Structure for holding migration result. A run sends one Result for
+// locking, one per migrated file and one for releasing the lock.
+type Result struct {
+	// phase of the run
+	Phase Phase
+
+	// Executed file, nil outside of PhaseMigrating
+	File *file.File
+
+	// direction and version of File
+	Direction direction.Direction
+	Version   uint64
+
+	// time the phase started and finished
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Duration   time.Duration
+
+	// statements executed for File, if the driver implements driver.StatementReporter
+	Statements []*driver.Statement
+
+	// Error
+	Error error
+}
+
+func newResult(phase Phase) Result {
+	return Result{
+		Phase:     phase,
+		StartedAt: time.Now(),
+	}
+}
+
+func newFileResult(f *file.File) Result {
+	result := newResult(PhaseMigrating)
+	result.File = f
+	result.Direction = f.Direction
+	result.Version = f.Version
+	return result
+}
+
+func (r Result) finish(err error) Result {
+	r.FinishedAt = time.Now()
+	r.Duration = r.FinishedAt.Sub(r.StartedAt)
+	r.Error = err
+	return r
+}
@@ -0,0 +1,126 @@
+package tractor
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/netw00rk/sqltractor/driver"
+	"github.com/netw00rk/sqltractor/tractor/migration"
+	"github.com/netw00rk/sqltractor/tractor/migration/direction"
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
+)
+
+// Squash consolidates the migrations up to and including version into one up
+// and one down file at version, which replace the files listed in replaced.
+// Databases already past version keep working, as Validate accepts the
+// squashed file in place of the migrations it replaces. Databases between the
+// first replaced version and version refuse to apply the squashed file, and
+// databases that applied the replaced migrations one by one refuse to revert
+// it, see checkSquashed. Squash does not touch the reader, writing the files
+// is up to the caller.
+func (t *SqlTractor) Squash(version uint64) (up, down *file.File, replaced []*file.File, err error) {
+	manager, err := t.manager()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return manager.Squash(version)
+}
+
+// checkSquashed fails if a file in files is a squashed migration the database
+// at version has not applied as one. An up file fails if the database has
+// applied a part of it already, as running it would repeat those migrations.
+// Such databases have to be migrated to the version of the squashed migration
+// with the files from before the squash. A down file fails if the database has
+// applied the migrations it replaces one by one, as it reverts all of them but
+// removes only its own version. Drivers that do not implement
+// driver.VersionLister record nothing but the current version, so down files
+// are not checked for them.
+func (t *SqlTractor) checkSquashed(ctx context.Context, manager migration.Manager, version uint64, files []*file.File) error {
+	var applied map[uint64]bool
+	for _, f := range files {
+		if f.Func != nil || (f.Direction == direction.Up && f.Version <= version) {
+			continue
+		}
+
+		// only the up file lists the versions a squashed migration replaces
+		up := f
+		if f.Direction == direction.Down {
+			if up = upFile(manager, f.Version); up == nil || up.Func != nil {
+				continue
+			}
+		}
+
+		squashed, err := up.SquashedVersions()
+		if err != nil {
+			return err
+		}
+		if squashed == nil {
+			continue
+		}
+
+		versions := make([]string, len(squashed))
+		for i, v := range squashed {
+			versions[i] = strconv.FormatUint(v, 10)
+		}
+
+		if f.Direction == direction.Up {
+			for _, v := range squashed {
+				if v <= version {
+					return fmt.Errorf("database at version %d has applied part of the squashed migration %s (versions %s), migrate it to version %d with the files from before the squash",
+						version, f.FileName, strings.Join(versions, " "), f.Version)
+				}
+			}
+			continue
+		}
+
+		if applied == nil {
+			if applied, err = t.appliedSet(ctx); err != nil {
+				return err
+			}
+		}
+		for _, v := range squashed {
+			if v != f.Version && applied[v] {
+				return fmt.Errorf("database has applied the migrations replaced by the squashed migration %s (versions %s) one by one, migrate it down with the files from before the squash",
+					f.FileName, strings.Join(versions, " "))
+			}
+		}
+	}
+	return nil
+}
+
+// upFile returns the up file of version in manager, or nil if there is none
+func upFile(manager migration.Manager, version uint64) *file.File {
+	for _, m := range manager {
+		if m.Version == version {
+			return m.UpFile
+		}
+	}
+	return nil
+}
+
+// appliedSet returns the applied versions as a set, which is empty
+// if the driver does not implement driver.VersionLister
+func (t *SqlTractor) appliedSet(ctx context.Context) (map[uint64]bool, error) {
+	d, err := t.driver()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[uint64]bool)
+	lister, ok := d.(driver.VersionLister)
+	if !ok {
+		return applied, nil
+	}
+
+	versions, err := lister.AppliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		applied[v] = true
+	}
+	return applied, nil
+}
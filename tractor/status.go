@@ -0,0 +1,141 @@
+package tractor
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/netw00rk/sqltractor/driver"
+)
+
+// MigrationStatus describes a migration known from
+// the migration files or from the applied versions.
+type MigrationStatus struct {
+	// version of the migration
+	Version uint64
+
+	// migration name parsed from the filenames, or recorded
+	// by the driver if the files are missing
+	Name string
+
+	// whether the up and down files exist
+	HasUp   bool
+	HasDown bool
+
+	// whether the database applied the migration
+	Applied bool
+
+	// time the migration was applied, zero if the driver does not record it
+	AppliedAt time.Time
+
+	// applied in the database, but neither file exists
+	Missing bool
+}
+
+// Status lists every migration, from the files and from the database,
+// ordered by version. Applied versions are taken from driver.Historian,
+// driver.VersionLister or, for drivers implementing neither, assumed to be
+// all versions up to the current one. Versions replaced by a squashed
+// migration are not reported as missing.
+func (t *SqlTractor) Status(ctx context.Context) ([]*MigrationStatus, error) {
+	manager, err := t.manager()
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := t.appliedRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[uint64]*MigrationStatus)
+	squashedInto := make(map[uint64]uint64)
+	for _, migration := range manager {
+		status := &MigrationStatus{
+			Version: migration.Version,
+			HasUp:   migration.UpFile != nil,
+			HasDown: migration.DownFile != nil,
+		}
+
+		if migration.UpFile != nil {
+			status.Name = migration.UpFile.Name
+			squashed, err := migration.UpFile.SquashedVersions()
+			if err != nil {
+				return nil, err
+			}
+			for _, version := range squashed {
+				if version != migration.Version {
+					squashedInto[version] = migration.Version
+				}
+			}
+		} else if migration.DownFile != nil {
+			status.Name = migration.DownFile.Name
+		}
+
+		statuses[migration.Version] = status
+	}
+
+	for _, record := range records {
+		status, ok := statuses[record.Version]
+		if !ok {
+			if _, squashed := squashedInto[record.Version]; squashed {
+				continue
+			}
+			status = &MigrationStatus{Version: record.Version, Name: record.Name, Missing: true}
+			statuses[record.Version] = status
+		}
+
+		status.Applied = true
+		status.AppliedAt = record.AppliedAt
+	}
+
+	list := make([]*MigrationStatus, 0, len(statuses))
+	for _, status := range statuses {
+		list = append(list, status)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+
+	return list, nil
+}
+
+// appliedRecords returns a record for every applied version, with
+// only the version set unless the driver implements driver.Historian
+func (t *SqlTractor) appliedRecords(ctx context.Context) ([]*driver.Record, error) {
+	d, err := t.driver()
+	if err != nil {
+		return nil, err
+	}
+
+	if historian, ok := d.(driver.Historian); ok {
+		return historian.History(ctx)
+	}
+
+	var versions []uint64
+	if lister, ok := d.(driver.VersionLister); ok {
+		if versions, err = lister.AppliedVersions(ctx); err != nil {
+			return nil, err
+		}
+	} else {
+		current, err := d.VersionContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		manager, err := t.manager()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, migration := range manager {
+			if migration.Version <= current {
+				versions = append(versions, migration.Version)
+			}
+		}
+	}
+
+	records := make([]*driver.Record, 0, len(versions))
+	for _, version := range versions {
+		records = append(records, &driver.Record{Version: version})
+	}
+	return records, nil
+}
@@ -1,41 +1,68 @@
 package tractor
 
 import (
+	"context"
+
 	"github.com/netw00rk/sqltractor/tractor/migration/file"
 )
 
 func Up(t Tractor) ([]*file.File, error) {
-	files := make([]*file.File, 0)
-	for r := range t.UpAsync() {
-		if r.Error != nil {
-			return files, r.Error
-		}
-		files = append(files, r.File)
-	}
+	return UpContext(context.Background(), t)
+}
 
-	return files, nil
+func UpContext(ctx context.Context, t Tractor) ([]*file.File, error) {
+	return collect(t.UpAsyncContext(ctx))
 }
 
 func Down(t Tractor) ([]*file.File, error) {
-	files := make([]*file.File, 0)
-	for r := range t.DownAsync() {
-		if r.Error != nil {
-			return files, r.Error
-		}
-		files = append(files, r.File)
-	}
+	return DownContext(context.Background(), t)
+}
 
-	return files, nil
+func DownContext(ctx context.Context, t Tractor) ([]*file.File, error) {
+	return collect(t.DownAsyncContext(ctx))
 }
 
 func Migrate(t Tractor, relativeN int) ([]*file.File, error) {
+	return MigrateContext(context.Background(), t, relativeN)
+}
+
+func MigrateContext(ctx context.Context, t Tractor, relativeN int) ([]*file.File, error) {
+	return collect(t.MigrateAsyncContext(ctx, relativeN))
+}
+
+func Goto(t Tractor, target uint64) ([]*file.File, error) {
+	return GotoContext(context.Background(), t, target)
+}
+
+func GotoContext(ctx context.Context, t Tractor, target uint64) ([]*file.File, error) {
+	return collect(t.GotoAsyncContext(ctx, target))
+}
+
+func Redo(t Tractor, n int) ([]*file.File, error) {
+	return RedoContext(context.Background(), t, n)
+}
+
+func RedoContext(ctx context.Context, t Tractor, n int) ([]*file.File, error) {
+	return collect(t.RedoAsyncContext(ctx, n))
+}
+
+// collect drains results and returns the migrated files and the first error.
+// Draining makes sure the lock is released once the wrappers return.
+func collect(results chan Result) ([]*file.File, error) {
 	files := make([]*file.File, 0)
-	for r := range t.MigrateAsync(relativeN) {
+	var err error
+	for r := range results {
 		if r.Error != nil {
-			return files, r.Error
+			if err == nil {
+				err = r.Error
+			}
+			continue
+		}
+
+		if r.Phase == PhaseMigrating && r.File != nil {
+			files = append(files, r.File)
 		}
-		files = append(files, r.File)
 	}
 
-	return files, nil
+	return files, err
 }
@@ -1,27 +1,30 @@
 package tractor
 
 import (
+	"context"
+	"errors"
+	"time"
+
 	"github.com/netw00rk/sqltractor/driver"
 	"github.com/netw00rk/sqltractor/reader"
 	"github.com/netw00rk/sqltractor/tractor/migration"
 	"github.com/netw00rk/sqltractor/tractor/migration/file"
 )
 
-// Structure for holding migration result
-type Result struct {
-	// Executed file
-	File *file.File
-
-	// Error
-	Error error
-}
-
 // Tractor interface
 type Tractor interface {
 	UpAsync() chan Result
+	UpAsyncContext(context.Context) chan Result
 	DownAsync() chan Result
+	DownAsyncContext(context.Context) chan Result
 	MigrateAsync(int) chan Result
+	MigrateAsyncContext(context.Context, int) chan Result
+	GotoAsync(uint64) chan Result
+	GotoAsyncContext(context.Context, uint64) chan Result
+	RedoAsync(int) chan Result
+	RedoAsyncContext(context.Context, int) chan Result
 	Version() (uint64, error)
+	VersionContext(context.Context) (uint64, error)
 }
 
 // SqlTractor is main structure to work with migration.
@@ -29,6 +32,31 @@ type SqlTractor struct {
 	Driver driver.Driver
 	Reader reader.Reader
 
+	// IgnoreDrift lets UpAsync run although applied migration
+	// files have drifted from the recorded history, see Validate
+	IgnoreDrift bool
+
+	// OutOfOrder makes UpAsync apply every migration missing from the
+	// applied versions, including versions lower than the current one.
+	// The driver has to implement driver.VersionLister.
+	OutOfOrder bool
+
+	// Observers are notified around every run and every migrated file
+	Observers []Observer
+
+	// ManagerOptions configures how conflicting migration files,
+	// like two up files of one version, are reported
+	ManagerOptions migration.Options
+
+	// LockTimeout is how long to wait for a lock held by another
+	// process before failing with a LockedError. 0 fails at once.
+	LockTimeout time.Duration
+
+	// LockTTL is how long a lock may go without heartbeat before it is
+	// considered stale and taken over. While a lock is held, its heartbeat
+	// is refreshed every third of LockTTL. 0 disables both.
+	LockTTL time.Duration
+
 	_manager migration.Manager
 }
 
@@ -42,126 +70,295 @@ func NewSqlTractor(driver driver.Driver, reader reader.Reader) Tractor {
 
 // Applies all available migrations asynchronously
 func (t *SqlTractor) UpAsync() chan Result {
-	version, err := t.Version()
+	return t.UpAsyncContext(context.Background())
+}
+
+// Applies all available migrations asynchronously. Cancelling ctx stops
+// before the next file and aborts the statement in flight where the driver allows it.
+// Refuses to run while files drifted from the recorded history, unless IgnoreDrift is set.
+func (t *SqlTractor) UpAsyncContext(ctx context.Context) chan Result {
+	sel := t.selectUp(ctx)
+	return t.applyAsync(ctx, func(ctx context.Context) ([]*file.File, error) {
+		if err := t.checkDrift(ctx); err != nil {
+			return nil, err
+		}
+		return t.selectClean(ctx, sel)
+	})
+}
+
+// Rolls back all migrations asynchronously
+func (t *SqlTractor) DownAsync() chan Result {
+	return t.DownAsyncContext(context.Background())
+}
+
+// Rolls back all migrations asynchronously, honouring cancellation of ctx
+func (t *SqlTractor) DownAsyncContext(ctx context.Context) chan Result {
+	return t.selectAndApplyAsync(ctx, selectDown)
+}
+
+// Applies relative +n/-n migrations asynchronously
+func (t *SqlTractor) MigrateAsync(relativeN int) chan Result {
+	return t.MigrateAsyncContext(context.Background(), relativeN)
+}
+
+// Applies relative +n/-n migrations asynchronously, honouring cancellation of ctx
+func (t *SqlTractor) MigrateAsyncContext(ctx context.Context, relativeN int) chan Result {
+	return t.selectAndApplyAsync(ctx, selectRelative(relativeN))
+}
+
+// Migrates up or down to the target version asynchronously
+func (t *SqlTractor) GotoAsync(target uint64) chan Result {
+	return t.GotoAsyncContext(context.Background(), target)
+}
+
+// Migrates up or down to the target version asynchronously, honouring cancellation of ctx
+func (t *SqlTractor) GotoAsyncContext(ctx context.Context, target uint64) chan Result {
+	return t.selectAndApplyAsync(ctx, selectGoto(target))
+}
+
+// Rolls back and re-applies the last n migrations asynchronously
+func (t *SqlTractor) RedoAsync(n int) chan Result {
+	return t.RedoAsyncContext(context.Background(), n)
+}
+
+// Rolls back and re-applies the last n migrations asynchronously, honouring
+// cancellation of ctx. Both directions run under a single lock, and no up
+// file is applied once a down file failed.
+func (t *SqlTractor) RedoAsyncContext(ctx context.Context, n int) chan Result {
+	return t.selectAndApplyAsync(ctx, selectRedo(n))
+}
+
+// Returns all applied migration versions in ascending order.
+// The driver has to implement driver.VersionLister.
+func (t *SqlTractor) AppliedVersions(ctx context.Context) ([]uint64, error) {
+	d, err := t.driver()
 	if err != nil {
-		return t.wrapAsyncError(err)
+		return nil, err
 	}
 
-	manager, err := t.manager()
-	if err != nil {
-		return t.wrapAsyncError(err)
+	lister, ok := d.(driver.VersionLister)
+	if !ok {
+		return nil, errors.New("driver does not list applied versions")
 	}
 
-	return t.applyAsync(manager.ToLastFrom(version))
+	return lister.AppliedVersions(ctx)
 }
 
-// Rolls back all migrations asynchronously
-func (t *SqlTractor) DownAsync() chan Result {
-	version, err := t.Version()
+// Returns the current migration version
+func (t *SqlTractor) Version() (uint64, error) {
+	return t.VersionContext(context.Background())
+}
+
+// Returns the current migration version, honouring cancellation of ctx
+func (t *SqlTractor) VersionContext(ctx context.Context) (uint64, error) {
+	driver, err := t.driver()
 	if err != nil {
-		return t.wrapAsyncError(err)
+		return 0, err
 	}
 
-	manager, err := t.manager()
-	if err != nil {
-		return t.wrapAsyncError(err)
+	return driver.VersionContext(ctx)
+}
+
+// selector picks the migration files to apply, starting from the current version
+type selector func(manager migration.Manager, version uint64) ([]*file.File, error)
+
+func selectUp(manager migration.Manager, version uint64) ([]*file.File, error) {
+	return manager.ToLastFrom(version), nil
+}
+
+// selectUp picks the pending set difference in out-of-order mode
+func (t *SqlTractor) selectUp(ctx context.Context) selector {
+	if !t.OutOfOrder {
+		return selectUp
 	}
 
-	return t.applyAsync(manager.ToFirstFrom(version))
+	return func(manager migration.Manager, version uint64) ([]*file.File, error) {
+		applied, err := t.AppliedVersions(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return manager.Pending(applied), nil
+	}
 }
 
-// Applies relative +n/-n migrations asynchronously
-func (t *SqlTractor) MigrateAsync(relativeN int) chan Result {
-	version, err := t.Version()
+func selectDown(manager migration.Manager, version uint64) ([]*file.File, error) {
+	return manager.ToFirstFrom(version), nil
+}
+
+func selectRelative(relativeN int) selector {
+	return func(manager migration.Manager, version uint64) ([]*file.File, error) {
+		return manager.From(version, relativeN), nil
+	}
+}
+
+func selectGoto(target uint64) selector {
+	return func(manager migration.Manager, version uint64) ([]*file.File, error) {
+		return manager.GotoFrom(version, target)
+	}
+}
+
+func selectRedo(n int) selector {
+	return func(manager migration.Manager, version uint64) ([]*file.File, error) {
+		return manager.Redo(version, n)
+	}
+}
+
+// selectFiles resolves the current version and the files chosen by sel
+func (t *SqlTractor) selectFiles(ctx context.Context, sel selector) (uint64, []*file.File, error) {
+	version, err := t.VersionContext(ctx)
 	if err != nil {
-		return t.wrapAsyncError(err)
+		return 0, nil, err
 	}
 
 	manager, err := t.manager()
 	if err != nil {
-		return t.wrapAsyncError(err)
+		return 0, nil, err
+	}
+
+	files, err := sel(manager, version)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := t.checkSquashed(ctx, manager, version, files); err != nil {
+		return 0, nil, err
 	}
 
-	return t.applyAsync(manager.From(version, relativeN))
+	return version, files, nil
 }
 
-// Returns the current migration version
-func (t *SqlTractor) Version() (uint64, error) {
-	driver, err := t.driver()
-	if err != nil {
-		return 0, err
+// selectClean refuses to start from a dirty version, see Force
+func (t *SqlTractor) selectClean(ctx context.Context, sel selector) ([]*file.File, error) {
+	if err := t.checkDirty(ctx); err != nil {
+		return nil, err
 	}
 
-	return driver.Version()
+	_, files, err := t.selectFiles(ctx, sel)
+	return files, err
 }
 
-func (t *SqlTractor) wrapAsyncError(err error) chan Result {
-	result := make(chan Result)
-	go func(result chan Result) {
-		result <- Result{nil, err}
-		close(result)
-	}(result)
-	return result
+func (t *SqlTractor) selectAndApplyAsync(ctx context.Context, sel selector) chan Result {
+	return t.applyAsync(ctx, func(ctx context.Context) ([]*file.File, error) {
+		return t.selectClean(ctx, sel)
+	})
 }
 
-func (t *SqlTractor) applyAsync(files []*file.File) chan Result {
+// preparer checks the database and picks the files to migrate. It runs once
+// the lock is held, so that no other process changes the version in between.
+type preparer func(ctx context.Context) ([]*file.File, error)
+
+func (t *SqlTractor) applyAsync(ctx context.Context, prepare preparer) chan Result {
 	resultChan := make(chan Result)
-	go t.apply(files, resultChan)
+	go t.apply(ctx, prepare, resultChan)
 	return resultChan
 }
 
-func (t *SqlTractor) apply(files []*file.File, resultChan chan Result) {
-	if err := t.lock(); err != nil {
-		resultChan <- Result{nil, err}
-		close(resultChan)
+func (t *SqlTractor) apply(ctx context.Context, prepare preparer, resultChan chan Result) {
+	defer close(resultChan)
+
+	locking := newResult(PhaseLocking)
+	if err := t.lock(ctx); err != nil {
+		resultChan <- locking.finish(err)
+		t.afterRun(ctx, err)
 		return
 	}
+	resultChan <- locking.finish(nil)
+
+	// a lost lock stops the run like a cancellation of ctx
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
+	stopHeartbeat := t.heartbeat(cancel)
+	preparing := newResult(PhasePreparing)
+	files, err := prepare(runCtx)
+	if err != nil {
+		resultChan <- preparing.finish(err)
+	} else {
+		err = t.run(runCtx, files, resultChan)
+	}
+	if lost := stopHeartbeat(); lost != nil {
+		resultChan <- newResult(PhaseLocking).finish(lost)
+		err = lost
+	}
+
+	t.afterRun(ctx, err)
+
+	releasing := newResult(PhaseReleasing)
+	resultChan <- releasing.finish(t.release())
+}
+
+// afterRun notifies the observers that the run stopped with err
+func (t *SqlTractor) afterRun(ctx context.Context, err error) {
+	for _, observer := range t.Observers {
+		observer.AfterRun(ctx, err)
+	}
+}
+
+// run migrates files in order and stops at the first error, which is
+// sent to resultChan and returned. Observers may veto any step.
+func (t *SqlTractor) run(ctx context.Context, files []*file.File, resultChan chan Result) error {
 	driver, err := t.driver()
 	if err != nil {
-		resultChan <- Result{nil, err}
-		t.release()
-		close(resultChan)
-		return
+		resultChan <- newResult(PhaseMigrating).finish(err)
+		return err
+	}
+
+	for _, observer := range t.Observers {
+		if err := observer.BeforeRun(ctx, files); err != nil {
+			resultChan <- newResult(PhaseMigrating).finish(err)
+			return err
+		}
 	}
 
 	for _, f := range files {
-		err := driver.Migrate(f)
-		resultChan <- Result{nil, err}
+		result := newFileResult(f)
+		if err := ctx.Err(); err != nil {
+			resultChan <- result.finish(err)
+			return err
+		}
+
+		err := t.migrate(ctx, driver, &result)
+		resultChan <- result
 
 		if err != nil {
-			t.release()
-			close(resultChan)
-			return
+			return err
 		}
 	}
 
-	t.release()
-	close(resultChan)
+	return nil
 }
 
-func (t *SqlTractor) lock() error {
-	driver, err := t.driver()
-	if err != nil {
-		return err
+// migrate applies the file of result, wrapped in the observers' callbacks
+func (t *SqlTractor) migrate(ctx context.Context, d driver.Driver, result *Result) error {
+	for _, observer := range t.Observers {
+		if err := observer.BeforeMigration(ctx, result.File); err != nil {
+			*result = result.finish(err)
+			return err
+		}
 	}
 
-	return driver.Lock()
-}
+	var err error
+	if reporter, ok := d.(driver.StatementReporter); ok {
+		result.Statements, err = reporter.MigrateWithStatements(ctx, result.File)
+	} else {
+		err = d.MigrateContext(ctx, result.File)
+	}
+	*result = result.finish(err)
 
-func (t *SqlTractor) release() error {
-	driver, err := t.driver()
-	if err != nil {
-		return err
+	for _, observer := range t.Observers {
+		if observerErr := observer.AfterMigration(ctx, result.File, result.Duration, err); observerErr != nil && err == nil {
+			err = observerErr
+			result.Error = err
+		}
 	}
 
-	return driver.Release()
+	return err
 }
 
 func (t *SqlTractor) manager() (migration.Manager, error) {
 	var err error
 	if t._manager == nil {
-		t._manager, err = migration.NewManager(t.Reader)
+		t._manager, err = migration.NewManagerWithOptions(t.Reader, t.ManagerOptions)
 	}
 
 	return t._manager, err
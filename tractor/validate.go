@@ -0,0 +1,166 @@
+package tractor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/netw00rk/sqltractor/driver"
+	"github.com/netw00rk/sqltractor/tractor/migration/file"
+)
+
+// Drift lists the differences between the migration files and
+// the history recorded by the driver.
+type Drift struct {
+	// up files of applied migrations whose content has changed since
+	Modified []*file.File
+
+	// applied migrations without an up file
+	Missing []*driver.Record
+
+	// up files below the most recent applied version that were never applied
+	Unknown []*file.File
+}
+
+// Empty reports whether files and history agree
+func (d *Drift) Empty() bool {
+	return len(d.Modified) == 0 && len(d.Missing) == 0 && len(d.Unknown) == 0
+}
+
+func (d *Drift) Error() string {
+	parts := make([]string, 0)
+	for _, f := range d.Modified {
+		parts = append(parts, fmt.Sprintf("modified %s", f.FileName))
+	}
+	for _, r := range d.Missing {
+		parts = append(parts, fmt.Sprintf("missing version %d", r.Version))
+	}
+	for _, f := range d.Unknown {
+		parts = append(parts, fmt.Sprintf("unknown %s", f.FileName))
+	}
+	return "migration files drifted from history: " + strings.Join(parts, ", ")
+}
+
+// Validate compares checksums of the migration files against the history
+// recorded by the driver. Records without a checksum, written by earlier
+// releases, are not compared. Neither are squashed migrations in databases
+// that applied the migrations they replace. Go migrations are compared by
+// file name only, changes to their function body are not reported. The
+// driver has to implement driver.Historian.
+func (t *SqlTractor) Validate(ctx context.Context) (*Drift, error) {
+	records, err := t.History(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	manager, err := t.manager()
+	if err != nil {
+		return nil, err
+	}
+
+	// squashedInto maps versions replaced by a squashed migration to its version
+	upFiles := make(map[uint64]*file.File)
+	squashedInto := make(map[uint64]uint64)
+	for _, migration := range manager {
+		if migration.UpFile == nil {
+			continue
+		}
+		upFiles[migration.Version] = migration.UpFile
+
+		squashed, err := migration.UpFile.SquashedVersions()
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range squashed {
+			if version != migration.Version {
+				squashedInto[version] = migration.Version
+			}
+		}
+	}
+
+	drift := &Drift{
+		Modified: make([]*file.File, 0),
+		Missing:  make([]*driver.Record, 0),
+		Unknown:  make([]*file.File, 0),
+	}
+
+	var latest uint64
+	applied := make(map[uint64]bool)
+	for _, record := range records {
+		applied[record.Version] = true
+		if record.Version > latest {
+			latest = record.Version
+		}
+	}
+
+	// databases that applied the migrations before they were squashed
+	// hold records of the replaced versions and of the original content
+	preSquash := make(map[uint64]bool)
+	for version, into := range squashedInto {
+		if applied[version] {
+			preSquash[into] = true
+		}
+	}
+
+	for _, record := range records {
+		f, ok := upFiles[record.Version]
+		if !ok {
+			if _, squashed := squashedInto[record.Version]; !squashed {
+				drift.Missing = append(drift.Missing, record)
+			}
+			continue
+		}
+
+		if record.Checksum == "" || preSquash[record.Version] {
+			continue
+		}
+
+		checksum, err := f.Checksum()
+		if err != nil {
+			return nil, err
+		}
+
+		if checksum != record.Checksum {
+			drift.Modified = append(drift.Modified, f)
+		}
+	}
+
+	for _, migration := range manager {
+		if migration.UpFile != nil && migration.Version < latest && !applied[migration.Version] {
+			drift.Unknown = append(drift.Unknown, migration.UpFile)
+		}
+	}
+
+	return drift, nil
+}
+
+// checkDrift fails if the driver records history and files drifted from it
+func (t *SqlTractor) checkDrift(ctx context.Context) error {
+	if t.IgnoreDrift {
+		return nil
+	}
+
+	d, err := t.driver()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := d.(driver.Historian); !ok {
+		return nil
+	}
+
+	drift, err := t.Validate(ctx)
+	if err != nil {
+		return err
+	}
+
+	if t.OutOfOrder {
+		// unknown files are exactly what out-of-order mode applies
+		drift.Unknown = drift.Unknown[:0]
+	}
+
+	if !drift.Empty() {
+		return drift
+	}
+	return nil
+}